@@ -19,33 +19,97 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/IBM/ibmcloud-volume-interface/provider/iam"
 	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
 	nodeupdater "github.com/IBM/vpc-node-label-updater/pkg/nodeupdater"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtimeu "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Rotation settings for file log output.
+	logMaxSizeMB  = 100
+	logMaxBackups = 3
+	logMaxAgeDays = 28
+
+	// Bounds of the final logger sync, so shutdown neither hangs nor gives up on the first failure.
+	logSyncAttempts = 3
+	logSyncTimeout  = time.Second
+)
+
+// Exit codes of a failed run, by error class, so orchestrators can react to each differently.
+const (
+	// exitCodeFailure is used for errors of no particular class.
+	exitCodeFailure = 1
+	// exitCodeAuth is used when no IAM token can be obtained, RIAAS rejects it or RIAAS lists no instances at all.
+	exitCodeAuth = 10
+	// exitCodeNotFound is used when the node or its VPC instance cannot be found.
+	exitCodeNotFound = 11
+	// exitCodeConnection is used when RIAAS or the secret provider cannot be reached.
+	exitCodeConnection = 12
+	// exitCodeConflict is used when the node keeps changing under the label update.
+	exitCodeConflict = 13
+	// exitCodeConfig is used when the configuration or the RIAAS endpoint is invalid.
+	exitCodeConfig = 14
 )
 
 var (
 	logger *zap.Logger
+	// exit terminates the process, replaceable in tests.
+	exit = os.Exit
+	// dryRun logs the labels the node would get without writing them, like DRY_RUN=true.
+	dryRun = flag.Bool("dry-run", false, "log the labels that would be set on the node without applying them")
+	// watch keeps the updater running as a controller that relabels the node when its labels go missing.
+	watch = flag.Bool("watch", false, "keep running after labeling and reapply the labels whenever they go missing from the node")
 )
 
 func init() {
 	_ = flag.Set("logtostderr", "true") // #nosec G104: Attempt to set flags for logging to stderr only on best-effort basis.Error cannot be usefully handled.
 	logger = setUpLogger()
 	defer func() {
-		_ = logger.Sync() // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+		_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
 	}()
 }
 
 func setUpLogger() *zap.Logger {
+	return newLogger(newLogSink(os.Getenv("LOG_OUTPUT")))
+}
+
+// newLogSink returns the sink for the given LOG_OUTPUT value: stdout (the default), stderr,
+// or a file path which is rotated by size.
+func newLogSink(output string) zapcore.WriteSyncer {
+	switch output {
+	case "", "stdout":
+		return zapcore.Lock(os.Stdout)
+	case "stderr":
+		return zapcore.Lock(os.Stderr)
+	}
+	return zapcore.AddSync(&lumberjack.Logger{
+		Filename:   output,
+		MaxSize:    logMaxSizeMB,
+		MaxBackups: logMaxBackups,
+		MaxAge:     logMaxAgeDays,
+	})
+}
+
+func newLogger(sink zapcore.WriteSyncer) *zap.Logger {
 	// Prepare a new logger
 	atom := zap.NewAtomicLevel()
 	encoderCfg := zap.NewProductionEncoderConfig()
@@ -54,7 +118,7 @@ func setUpLogger() *zap.Logger {
 
 	logger := zap.New(zapcore.NewCore(
 		zapcore.NewJSONEncoder(encoderCfg),
-		zapcore.Lock(os.Stdout),
+		sink,
 		atom,
 	), zap.AddCaller()).With(zap.String("watcher-name", "vpc-node-label-updater"))
 
@@ -62,19 +126,87 @@ func setUpLogger() *zap.Logger {
 	return logger
 }
 
+// syncLogger flushes the logger, retrying up to attempts times. Each attempt is bounded by
+// timeout; a sync that does not return in time is abandoned.
+func syncLogger(l *zap.Logger, attempts int, timeout time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		done := make(chan error, 1)
+		go func() {
+			done <- l.Sync()
+		}()
+		select {
+		case err = <-done:
+			if err == nil {
+				return nil
+			}
+		case <-time.After(timeout):
+			err = fmt.Errorf("logger sync timed out after %s", timeout)
+		}
+	}
+	return err
+}
+
 func main() {
+	defer func() {
+		_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	}()
+	flag.Parse()
+	// Cancelled on pod shutdown, so retries stop promptly instead of sleeping through the grace period.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	logger.Info("Starting controller for adding node labels")
-	k8sClient, err := k8s_utils.Getk8sClientSet()
+	cfg := nodeupdater.LoadConfig(logger)
+	cfg.DryRun = cfg.DryRun || *dryRun
+	if (flag.Arg(0) == "diff" || flag.Arg(0) == "cleanup") && flag.Arg(1) != "" {
+		cfg.NodeName = flag.Arg(1)
+	}
+	diagnostics := &nodeupdater.Diagnostics{NodeName: cfg.NodeName, Config: cfg}
+	// The snapshot is read-only and covers every node, so it needs neither the node settings nor the node name.
+	validate := cfg.Validate
+	if flag.Arg(0) == "snapshot" {
+		validate = cfg.ValidateLabelKeys
+	}
+	// Logged before anything can fail, so the configuration of every run and subcommand is visible.
+	err := validate()
+	cfg.LogEffectiveConfig(logger)
 	if err != nil {
-		logger.Fatal("Failed to kubernetes create client set", zap.Error(err))
+		fatal("Invalid configuration", err, diagnostics)
+	}
+	k8sClient, err := nodeupdater.NewK8sClient(cfg)
+	if err != nil {
+		fatal("Failed to kubernetes create client set", err, diagnostics)
+	}
+	if flag.Arg(0) == "snapshot" {
+		os.Exit(snapshot(ctx, k8sClient.Clientset, cfg, os.Stdout))
+	}
+	nodeName, err := nodeupdater.ResolveNodeName(ctx, k8sClient.Clientset, cfg, logger)
+	if err != nil {
+		fatalUnlessShutdown(ctx, "Failed to resolve node name", err, diagnostics)
+	}
+	cfg.NodeName = nodeName
+	diagnostics.NodeName = nodeName
+
+	if code, ok := runSubcommand(ctx, flag.Arg(0), &k8sClient, cfg); ok {
+		os.Exit(code)
+	}
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := nodeupdater.ServeMetrics(ctx, cfg.MetricsAddr, logger); err != nil {
+				logger.Error("Failed to serve metrics", zap.String("metricsAddr", cfg.MetricsAddr), zap.Error(err))
+			}
+		}()
+	}
+	// Only the labeling run owns the success marker, the subcommands leave it alone.
+	if err := removeSuccessMarker(cfg.SuccessMarker); err != nil {
+		fatal("Failed to remove stale success marker", err, diagnostics)
 	}
-	nodeName := os.Getenv("NODE_NAME")
 
 	// Do multiple retries to get node details.
 	logger.Info("Getting node details")
 	var node *v1.Node
-	errRetry := nodeupdater.ErrorRetry(logger, func() (error, bool) {
-		node, err = k8sClient.Clientset.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	errRetry := cfg.ErrorRetry(ctx, logger, func() (error, bool) {
+		node, err = k8sClient.Clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 		if errors.IsNotFound(err) {
 			runtimeu.HandleError(fmt.Errorf("node '%s' no longer exist in the cluster", nodeName))
 			return err, true // Skip retry if node doesnot exist.
@@ -85,25 +217,250 @@ func main() {
 		return nil, true
 	})
 	if errRetry != nil || node == nil {
-		logger.Fatal("Failed to get node details. Error :", zap.Error(errRetry))
+		fatalUnlessShutdown(ctx, "Failed to get node details. Error :", errRetry, diagnostics)
 	}
 
-	if nodeupdater.CheckIfRequiredLabelsPresent(node.ObjectMeta.Labels) {
-		logger.Info("Required labels already present on the worker node")
+	// In watch mode a node with nothing to label now is still watched, as that may change.
+	reason := cfg.SkipReason(node)
+	if reason != "" {
+		nodeupdater.RecordSkip(logger, reason, "Nothing to label on the worker node")
+		markSuccess(cfg.SuccessMarker, diagnostics)
+		if !*watch {
+			return
+		}
+	}
+
+	if !nodeupdater.IsVPCInfrastructure(&k8sClient, logger) {
+		nodeupdater.RecordSkip(logger, nodeupdater.SkipReasonNotVPC, "Not running on VPC infrastructure, nothing to label")
+		markSuccess(cfg.SuccessMarker, diagnostics)
 		return
 	}
 
 	var secretConfig *nodeupdater.StorageSecretConfig
-	if secretConfig, err = nodeupdater.ReadSecretConfiguration(&k8sClient, logger); err != nil {
-		logger.Fatal("Failed to read secret configuration", zap.Error(err))
+	if secretConfig, err = nodeupdater.ReadSecretConfigurationForNode(ctx, &k8sClient, node, cfg, logger); err != nil {
+		fatalUnlessShutdown(ctx, "Failed to read secret configuration", err, diagnostics)
+	}
+	cfg.RiaasEndpoint = secretConfig.RiaasEndpointURL.String()
+	cfg.LogRIAASEndpoint(logger)
+	httpClient, err := nodeupdater.NewRiaasHTTPClient(cfg)
+	if err != nil {
+		fatal("Failed to create RIAAS http client", err, diagnostics)
 	}
 	c := &nodeupdater.VpcNodeLabelUpdater{
 		Node:                node,
 		K8sClient:           k8sClient.Clientset,
 		Logger:              logger,
 		StorageSecretConfig: secretConfig,
+		Webhook:             nodeupdater.NewWebhookNotifier(cfg.WebhookURL, cfg.WebhookTimeout, logger),
+		Config:              cfg,
+		HTTPClient:          httpClient,
+	}
+	diagnostics.Updater = c
+	if reason == "" {
+		_, err := c.UpdateNodeLabel(ctx, nodeName)
+		// Labeling does not wait for the webhook, but the process lets the delivery finish before exiting.
+		c.Webhook.Wait()
+		if err != nil {
+			fatalUnlessShutdown(ctx, "error in updating labels for node", err, diagnostics)
+		}
+		if !cfg.DryRun {
+			markSuccess(cfg.SuccessMarker, diagnostics)
+		}
+	}
+	if *watch {
+		// Runs until SIGTERM cancels ctx.
+		c.WatchNodeLabels(ctx, nodeName)
+	}
+}
+
+// runSubcommand runs the node subcommand called name, returning its exit code and whether there is one by that name.
+func runSubcommand(ctx context.Context, name string, k8sClient *k8s_utils.KubernetesClient, cfg *nodeupdater.Config) (int, bool) {
+	switch name {
+	case "verify":
+		return verify(ctx, k8sClient.Clientset, cfg), true
+	case "diff":
+		return diff(ctx, k8sClient, cfg, os.Stdout), true
+	case "cleanup":
+		return cleanup(ctx, k8sClient.Clientset, cfg), true
+	}
+	return 0, false
+}
+
+// markSuccess writes the success marker, if configured, exiting if it cannot be written.
+func markSuccess(path string, diagnostics *nodeupdater.Diagnostics) {
+	if err := writeSuccessMarker(path); err != nil {
+		fatal("Failed to write success marker", err, diagnostics)
+	}
+}
+
+// writeSuccessMarker writes the marker file at path recording the time of success. An empty path is a no-op.
+func writeSuccessMarker(path string) error {
+	if path == "" {
+		return nil
+	}
+	return os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644) // #nosec G306: the marker holds no secrets and is read by other containers.
+}
+
+// removeSuccessMarker removes a marker left by a previous run so it only reflects this one. An empty path is a no-op.
+func removeSuccessMarker(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fatalUnlessShutdown exits like fatal, unless err is the cancellation of ctx by a shutdown signal. The
+// interrupted work is then logged and the process exits cleanly.
+func fatalUnlessShutdown(ctx context.Context, msg string, err error, diagnostics *nodeupdater.Diagnostics) {
+	if ctx.Err() == nil || !stderrors.Is(err, context.Canceled) {
+		fatal(msg, err, diagnostics)
+		return
+	}
+	logger.Info("Shutting down on signal before the node labels were updated", zap.String("interrupted", msg), zap.Error(err))
+	_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	exit(0)
+}
+
+// fatal logs msg and err together with the diagnostics bundle, then exits.
+func fatal(msg string, err error, diagnostics *nodeupdater.Diagnostics) {
+	code := exitCode(err)
+	logger.Error(msg, zap.Error(err), zap.Int("exitCode", code), zap.Object("diagnostics", diagnostics))
+	_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	exit(code)
+}
+
+// exitCode maps err to the exit code of its error class.
+func exitCode(err error) int {
+	var statusErr *nodeupdater.ErrRIAASStatus
+	var invalidURL *nodeupdater.ErrInvalidRIAASURL
+	switch {
+	case err == nil:
+		return exitCodeFailure
+	case stderrors.Is(err, nodeupdater.ErrIAMToken), stderrors.As(err, &statusErr) && statusErr.IsAuthError(),
+		stderrors.Is(err, nodeupdater.ErrEmptyInstanceList):
+		return exitCodeAuth
+	case stderrors.Is(err, nodeupdater.ErrInstanceNotFound), errors.IsNotFound(err):
+		return exitCodeNotFound
+	case stderrors.Is(err, nodeupdater.ErrSecretProviderTimeout), iam.IsConnectionError(err), isNetError(err):
+		return exitCodeConnection
+	case errors.IsConflict(err):
+		return exitCodeConflict
+	case stderrors.Is(err, nodeupdater.ErrInvalidConfig), stderrors.Is(err, nodeupdater.ErrEmptyRIAASEndpoint), stderrors.As(err, &invalidURL):
+		return exitCodeConfig
+	}
+	return exitCodeFailure
+}
+
+// isNetError reports whether err is a network error such as a failed dial.
+func isNetError(err error) bool {
+	var netErr net.Error
+	return stderrors.As(err, &netErr)
+}
+
+// snapshot writes, without making changes, the current values of the managed labels on every node to w as JSON.
+// Returns exit code 0 on success and 2 if the nodes could not be listed or the snapshot written.
+func snapshot(ctx context.Context, k8sClient kubernetes.Interface, cfg *nodeupdater.Config, w io.Writer) int {
+	defer func() {
+		_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	}()
+	snapshots, err := nodeupdater.SnapshotManagedLabels(ctx, k8sClient, cfg)
+	if err != nil {
+		logger.Error("Failed to snapshot managed node labels", zap.Error(err))
+		return 2
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(snapshots); err != nil {
+		logger.Error("Failed to write managed node labels snapshot", zap.Error(err))
+		return 2
+	}
+	return 0
+}
+
+// diff writes, without making changes, the differences between the managed labels the node would get and
+// the labels it carries to w as JSON. Returns exit code 0 if there are none, 1 if there are and 2 on error.
+func diff(ctx context.Context, k8sClient *k8s_utils.KubernetesClient, cfg *nodeupdater.Config, w io.Writer) int {
+	defer func() {
+		_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	}()
+	node, err := k8sClient.Clientset.CoreV1().Nodes().Get(ctx, cfg.NodeName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("Failed to get node", zap.String("nodeName", cfg.NodeName), zap.Error(err))
+		return 2
+	}
+	secretConfig, err := nodeupdater.ReadSecretConfigurationForNode(ctx, k8sClient, node, cfg, logger)
+	if err != nil {
+		logger.Error("Failed to read secret configuration", zap.Error(err))
+		return 2
+	}
+	httpClient, err := nodeupdater.NewRiaasHTTPClient(cfg)
+	if err != nil {
+		logger.Error("Failed to create RIAAS http client", zap.Error(err))
+		return 2
+	}
+	c := &nodeupdater.VpcNodeLabelUpdater{
+		Node:                node,
+		K8sClient:           k8sClient.Clientset,
+		Logger:              logger,
+		StorageSecretConfig: secretConfig,
+		Config:              cfg,
+		HTTPClient:          httpClient,
+	}
+	labelDiff, err := c.DiffNodeLabels(ctx, cfg.NodeName)
+	if err != nil {
+		logger.Error("Failed to compute node label differences", zap.String("nodeName", cfg.NodeName), zap.Error(err))
+		return 2
+	}
+	return writeDiff(labelDiff, w)
+}
+
+// writeDiff writes the label differences to w as JSON, returning the exit code of the diff command.
+func writeDiff(labelDiff *nodeupdater.LabelDiff, w io.Writer) int {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(labelDiff); err != nil {
+		logger.Error("Failed to write node label differences", zap.Error(err))
+		return 2
+	}
+	if labelDiff.IsEmpty() {
+		return 0
+	}
+	return 1
+}
+
+// cleanup removes the labels the updater recorded as applied from the node. Returns exit code 0 on
+// success and the code of the error class otherwise.
+func cleanup(ctx context.Context, k8sClient kubernetes.Interface, cfg *nodeupdater.Config) int {
+	defer func() {
+		_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	}()
+	removed, err := nodeupdater.CleanupManagedLabels(ctx, k8sClient, cfg, logger)
+	if err != nil {
+		logger.Error("Failed to clean up node labels", zap.String("nodeName", cfg.NodeName), zap.Error(err))
+		return exitCode(err)
+	}
+	logger.Info("Cleaned up node labels", zap.String("nodeName", cfg.NodeName), zap.Strings("removedLabels", removed), zap.Bool("dryRun", cfg.DryRun))
+	return 0
+}
+
+// verify checks, without making changes, whether the node carries all required labels.
+// Returns exit code 0 if present, 1 if absent and 2 if the node could not be read.
+func verify(ctx context.Context, k8sClient kubernetes.Interface, cfg *nodeupdater.Config) int {
+	defer func() {
+		_ = syncLogger(logger, logSyncAttempts, logSyncTimeout) // #nosec G104: Attempt to logg sync only on best-effort basis.Error cannot be usefully handled.
+	}()
+	present, err := nodeupdater.VerifyNodeLabels(ctx, k8sClient, cfg)
+	if err != nil {
+		logger.Error("Failed to verify node labels", zap.String("nodeName", cfg.NodeName), zap.Error(err))
+		return 2
 	}
-	if _, err := c.UpdateNodeLabel(context.TODO(), nodeName); err != nil {
-		logger.Fatal("error in updating labels for node", zap.Reflect("workerNodeName", nodeName), zap.Error(err))
+	if !present {
+		logger.Info("Required labels are missing on the worker node", zap.String("nodeName", cfg.NodeName))
+		return 1
 	}
+	logger.Info("Required labels already present on the worker node", zap.String("nodeName", cfg.NodeName))
+	return 0
 }
@@ -0,0 +1,269 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package main ...
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/ibmcloud-volume-interface/lib/provider"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	nodeupdater "github.com/IBM/vpc-node-label-updater/pkg/nodeupdater"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewLogSink(t *testing.T) {
+	assert.Equal(t, zapcore.Lock(os.Stdout), newLogSink(""))
+	assert.Equal(t, zapcore.Lock(os.Stdout), newLogSink("stdout"))
+	assert.Equal(t, zapcore.Lock(os.Stderr), newLogSink("stderr"))
+
+	logFile := filepath.Join(t.TempDir(), "updater.log")
+	logger := newLogger(newLogSink(logFile))
+	logger.Info("Logging to a file")
+	assert.Nil(t, logger.Sync())
+
+	content, err := os.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "Logging to a file")
+	assert.Contains(t, string(content), "vpc-node-label-updater")
+}
+
+// flakySink is a sink whose Sync fails the first failures times, or blocks when block is set.
+type flakySink struct {
+	bytes.Buffer
+	failures int
+	syncs    int
+	block    chan struct{}
+}
+
+func (s *flakySink) Sync() error {
+	s.syncs++
+	if s.block != nil {
+		<-s.block
+	}
+	if s.syncs <= s.failures {
+		return errors.New("sync failed")
+	}
+	return nil
+}
+
+func TestSyncLogger(t *testing.T) {
+	sink := &flakySink{failures: 1}
+	assert.Nil(t, syncLogger(newLogger(sink), 3, time.Second))
+	assert.Equal(t, 2, sink.syncs)
+
+	sink = &flakySink{failures: 5}
+	assert.NotNil(t, syncLogger(newLogger(sink), 3, time.Second))
+	assert.Equal(t, 3, sink.syncs)
+
+	sink = &flakySink{block: make(chan struct{})}
+	defer close(sink.block)
+	start := time.Now()
+	assert.NotNil(t, syncLogger(newLogger(sink), 1, 10*time.Millisecond))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// stubExit captures the logger output and replaces exit with a function panicking with the exit code.
+func stubExit(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	originalLogger, originalExit := logger, exit
+	t.Cleanup(func() { logger, exit = originalLogger, originalExit })
+	logger = newLogger(zapcore.AddSync(buf))
+	exit = func(code int) { panic(code) }
+	return buf
+}
+
+func TestFatal(t *testing.T) {
+	buf := stubExit(t)
+
+	diagnostics := &nodeupdater.Diagnostics{NodeName: "valid-worker", Config: &nodeupdater.Config{NodeName: "valid-worker"}}
+	assert.PanicsWithValue(t, exitCodeNotFound, func() {
+		fatal("Failed to get worker details", fmt.Errorf("worker was not found: %w", nodeupdater.ErrInstanceNotFound), diagnostics)
+	})
+	out := buf.String()
+	assert.Contains(t, out, `"msg":"Failed to get worker details"`)
+	assert.Contains(t, out, `"error":"worker was not found: instance not found"`)
+	assert.Contains(t, out, `"exitCode":11`)
+	assert.Contains(t, out, `"diagnostics":{"nodeName":"valid-worker","config":{"nodeName":"valid-worker"`)
+}
+
+func TestFatalUnlessShutdown(t *testing.T) {
+	buf := stubExit(t)
+	diagnostics := &nodeupdater.Diagnostics{NodeName: "valid-worker"}
+
+	// A failure while running exits with the code of its error class.
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.PanicsWithValue(t, exitCodeNotFound, func() {
+		fatalUnlessShutdown(ctx, "Failed to get worker details", fmt.Errorf("worker was not found: %w", nodeupdater.ErrInstanceNotFound), diagnostics)
+	})
+
+	// A failure after the root context is cancelled is a clean shutdown.
+	buf.Reset()
+	cancel()
+	assert.PanicsWithValue(t, 0, func() {
+		fatalUnlessShutdown(ctx, "error in updating labels for node", ctx.Err(), diagnostics)
+	})
+	out := buf.String()
+	assert.Contains(t, out, `"msg":"Shutting down on signal before the node labels were updated"`)
+	assert.Contains(t, out, `"interrupted":"error in updating labels for node"`)
+	assert.NotContains(t, out, `"exitCode"`)
+
+	// A wrapped cancellation is a clean shutdown too.
+	assert.PanicsWithValue(t, 0, func() {
+		fatalUnlessShutdown(ctx, "Failed to read secret configuration", &url.Error{Op: "Post", URL: "https://iam.cloud.ibm.com", Err: context.Canceled}, diagnostics)
+	})
+
+	// Any other failure after the shutdown signal still exits with the code of its error class.
+	assert.PanicsWithValue(t, exitCodeAuth, func() {
+		fatalUnlessShutdown(ctx, "Failed to read secret configuration", fmt.Errorf("%w: api key not found", nodeupdater.ErrIAMToken), diagnostics)
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{"topology.kubernetes.io/zone": "us-south-1"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}},
+	)
+	buf := &bytes.Buffer{}
+	assert.Equal(t, 0, snapshot(context.TODO(), clientset, &nodeupdater.Config{}, buf))
+
+	var snapshots []nodeupdater.NodeLabelSnapshot
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &snapshots))
+	assert.Equal(t, 2, len(snapshots))
+	assert.Equal(t, "us-south-1", *snapshots[0].Labels["topology.kubernetes.io/zone"])
+	assert.Nil(t, snapshots[1].Labels["topology.kubernetes.io/zone"])
+}
+
+func TestWriteDiff(t *testing.T) {
+	buf := &bytes.Buffer{}
+	labelDiff := &nodeupdater.LabelDiff{
+		NodeName:   "worker-1",
+		Missing:    map[string]string{"topology.kubernetes.io/region": "us-south"},
+		Extra:      map[string]string{},
+		Mismatched: map[string]nodeupdater.LabelValues{"topology.kubernetes.io/zone": {Desired: "us-south-1", Actual: "us-south-2"}},
+	}
+	assert.Equal(t, 1, writeDiff(labelDiff, buf))
+
+	var decoded nodeupdater.LabelDiff
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *labelDiff, decoded)
+	assert.Contains(t, buf.String(), `"desired": "us-south-1"`)
+
+	buf.Reset()
+	assert.Equal(t, 0, writeDiff(&nodeupdater.LabelDiff{NodeName: "worker-1"}, buf))
+}
+
+func TestSuccessMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labeled")
+
+	// An empty path disables the marker.
+	assert.Nil(t, writeSuccessMarker(""))
+	assert.Nil(t, removeSuccessMarker(""))
+
+	// Removing a missing marker is not an error.
+	assert.Nil(t, removeSuccessMarker(path))
+
+	assert.Nil(t, writeSuccessMarker(path))
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	_, err = time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	assert.Nil(t, err)
+
+	// A stale marker is removed at startup.
+	assert.Nil(t, removeSuccessMarker(path))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunSubcommandKeepsSuccessMarker(t *testing.T) {
+	stubExit(t)
+	path := filepath.Join(t.TempDir(), "labeled")
+	assert.Nil(t, writeSuccessMarker(path))
+
+	clientset := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "valid-worker"}})
+	cfg := &nodeupdater.Config{NodeName: "valid-worker", SuccessMarker: path}
+	code, ok := runSubcommand(context.TODO(), "verify", &k8s_utils.KubernetesClient{Clientset: clientset}, cfg)
+	assert.True(t, ok)
+	assert.Equal(t, 1, code)
+
+	// The marker of the last labeling run survives a verify.
+	_, err := os.Stat(path)
+	assert.Nil(t, err)
+
+	_, ok = runSubcommand(context.TODO(), "", &k8s_utils.KubernetesClient{Clientset: clientset}, cfg)
+	assert.False(t, ok)
+}
+
+func TestMarkSuccessFailure(t *testing.T) {
+	stubExit(t)
+
+	// The marker is only written by markSuccess; a failure to write it is fatal.
+	path := filepath.Join(t.TempDir(), "missing-dir", "labeled")
+	assert.Panics(t, func() {
+		markSuccess(path, &nodeupdater.Diagnostics{})
+	})
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		name    string
+		err     error
+		expCode int
+	}{
+		{name: "no error", err: nil, expCode: exitCodeFailure},
+		{name: "unclassified error", err: errors.New("unexpected"), expCode: exitCodeFailure},
+		{name: "IAM token error", err: fmt.Errorf("%w: api key not found", nodeupdater.ErrIAMToken), expCode: exitCodeAuth},
+		{name: "RIAAS unauthorized", err: &nodeupdater.ErrRIAASStatus{StatusCode: http.StatusUnauthorized}, expCode: exitCodeAuth},
+		{name: "RIAAS forbidden", err: &nodeupdater.ErrRIAASStatus{StatusCode: http.StatusForbidden}, expCode: exitCodeAuth},
+		{name: "RIAAS server error", err: &nodeupdater.ErrRIAASStatus{StatusCode: http.StatusBadGateway}, expCode: exitCodeFailure},
+		{name: "instance not found", err: fmt.Errorf("worker was not found: %w", nodeupdater.ErrInstanceNotFound), expCode: exitCodeNotFound},
+		{name: "unfiltered instance list empty", err: fmt.Errorf("list is empty: %w", nodeupdater.ErrEmptyInstanceList), expCode: exitCodeAuth},
+		{name: "node not found", err: apierrors.NewNotFound(schema.GroupResource{Resource: "nodes"}, "valid-worker"), expCode: exitCodeNotFound},
+		{name: "dial error", err: &url.Error{Op: "Get", URL: "https://riaas.invalid", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, expCode: exitCodeConnection},
+		{name: "IAM connection error", err: provider.Error{Fault: provider.Fault{Message: "token exchange failed", Wrapped: []string{"dial tcp: i/o timeout"}}}, expCode: exitCodeConnection},
+		{name: "secret provider timeout", err: fmt.Errorf("%w after 2m0s", nodeupdater.ErrSecretProviderTimeout), expCode: exitCodeConnection},
+		{name: "node conflict", err: apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "valid-worker", errors.New("object was modified")), expCode: exitCodeConflict},
+		{name: "invalid configuration", err: (&nodeupdater.Config{}).Validate(), expCode: exitCodeConfig},
+		{name: "wrapped invalid configuration", err: fmt.Errorf("%w: RETRY_INTERVAL must be positive", nodeupdater.ErrInvalidConfig), expCode: exitCodeConfig},
+		{name: "empty RIAAS endpoint", err: nodeupdater.ErrEmptyRIAASEndpoint, expCode: exitCodeConfig},
+		{name: "invalid RIAAS URL", err: &nodeupdater.ErrInvalidRIAASURL{URL: "riaas", Reason: "scheme and host must not be empty"}, expCode: exitCodeConfig},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		assert.Equal(t, tc.expCode, exitCode(tc.err))
+	}
+}
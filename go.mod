@@ -3,11 +3,13 @@ module github.com/IBM/vpc-node-label-updater
 go 1.18
 
 require (
+	github.com/IBM/go-sdk-core/v5 v5.9.1
 	github.com/IBM/ibmcloud-volume-interface v1.1.2
 	github.com/IBM/secret-common-lib v1.1.2
 	github.com/IBM/secret-utils-lib v1.1.2
 	github.com/stretchr/testify v1.8.0
 	go.uber.org/zap v1.20.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	k8s.io/api v0.25.4
 	k8s.io/apimachinery v0.25.4
 	k8s.io/client-go v0.25.4
@@ -16,7 +18,6 @@ require (
 require (
 	github.com/BurntSushi/toml v1.0.0 // indirect
 	github.com/IBM-Cloud/ibm-cloud-cli-sdk v0.6.7 // indirect
-	github.com/IBM/go-sdk-core/v5 v5.9.1 // indirect
 	github.com/PuerkitoBio/purell v1.1.1 // indirect
 	github.com/PuerkitoBio/urlesc v0.0.0-20170810143723-de5bf2ad4578 // indirect
 	github.com/asaskevich/govalidator v0.0.0-20200907205600-7a23bdc65eef // indirect
@@ -38,6 +39,7 @@ require (
 	github.com/golang-jwt/jwt/v4 v4.2.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/gnostic v0.5.7-v3refs // indirect
+	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/google/gofuzz v1.1.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hashicorp/go-retryablehttp v0.7.0 // indirect
@@ -0,0 +1,103 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package features parses the optional features enabled through ENABLE_* environment variables.
+package features
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	envPrefix = "ENABLE_"
+)
+
+// Flags holds the optional features of the updater.
+type Flags struct {
+	// LastReconcileAnnotation records the time of the last successful label update on the node.
+	LastReconcileAnnotation bool
+	// NodeCondition sets the VPCLabelsApplied condition on the node status after a successful update.
+	NodeCondition bool
+	// PodAnnotations records the discovered zone and region on the updater's own pod. It needs the
+	// opt-in pod patch RBAC in deploy/pod-annotations-rbac.yaml.
+	PodAnnotations bool
+	// LowercaseTopology lowercases the region and zone label values.
+	LowercaseTopology bool
+	// DevInstanceListFile allows reading the instance list from INSTANCE_LIST_FILE instead of RIAAS, for offline debugging.
+	DevInstanceListFile bool
+	// BootIDRelabel relabels the node when its boot ID differs from the one recorded at the last update.
+	BootIDRelabel bool
+	// InstanceProfileLabels also labels the node with the bandwidth of its instance profile, when known.
+	InstanceProfileLabels bool
+	// VpcIDLabelRequired treats a node without the VPC ID label as not yet labeled.
+	VpcIDLabelRequired bool
+}
+
+// known maps each supported ENABLE_* variable to the flag it sets.
+var known = map[string]func(*Flags) *bool{
+	"ENABLE_LAST_RECONCILE_ANNOTATION": func(f *Flags) *bool { return &f.LastReconcileAnnotation },
+	"ENABLE_NODE_CONDITION":            func(f *Flags) *bool { return &f.NodeCondition },
+	"ENABLE_POD_ANNOTATIONS":           func(f *Flags) *bool { return &f.PodAnnotations },
+	"ENABLE_LOWERCASE_TOPOLOGY":        func(f *Flags) *bool { return &f.LowercaseTopology },
+	"ENABLE_DEV_INSTANCE_LIST_FILE":    func(f *Flags) *bool { return &f.DevInstanceListFile },
+	"ENABLE_BOOT_ID_RELABEL":           func(f *Flags) *bool { return &f.BootIDRelabel },
+	"ENABLE_INSTANCE_PROFILE_LABELS":   func(f *Flags) *bool { return &f.InstanceProfileLabels },
+	"ENABLE_VPC_ID_LABEL_REQUIRED":     func(f *Flags) *bool { return &f.VpcIDLabelRequired },
+}
+
+// Load parses the ENABLE_* variables of environ, given in os.Environ form, into Flags.
+// Unknown variables and invalid values are logged and ignored so a typo never silently goes unnoticed.
+func Load(environ []string, logger *zap.Logger) *Flags {
+	flags := &Flags{}
+	var enabled []string
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+		flag, ok := known[name]
+		if !ok {
+			logger.Warn("Ignoring unknown feature flag", zap.String("env", name), zap.Strings("known", Names()))
+			continue
+		}
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			logger.Warn("Ignoring invalid feature flag value", zap.String("env", name), zap.String("value", value), zap.Error(err))
+			continue
+		}
+		*flag(flags) = on
+		if on {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	logger.Info("Feature flags", zap.Strings("enabled", enabled))
+	return flags
+}
+
+// Names returns the sorted names of all supported feature flags.
+func Names() []string {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
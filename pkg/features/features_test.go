@@ -0,0 +1,90 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package features ...
+package features
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newBufferLogger returns a logger whose JSON output is captured in the returned buffer.
+func newBufferLogger() (*zap.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(buf), zap.DebugLevel))
+	return logger, buf
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name       string
+		environ    []string
+		expFlags   *Flags
+		expWarning string
+	}{
+		{
+			name:     "no flags",
+			environ:  []string{"NODE_NAME=valid-worker"},
+			expFlags: &Flags{},
+		},
+		{
+			name:     "flag enabled",
+			environ:  []string{"ENABLE_LAST_RECONCILE_ANNOTATION=true"},
+			expFlags: &Flags{LastReconcileAnnotation: true},
+		},
+		{
+			name:     "several flags enabled",
+			environ:  []string{"ENABLE_LAST_RECONCILE_ANNOTATION=true", "ENABLE_NODE_CONDITION=1"},
+			expFlags: &Flags{LastReconcileAnnotation: true, NodeCondition: true},
+		},
+		{
+			name:     "flag disabled",
+			environ:  []string{"ENABLE_LAST_RECONCILE_ANNOTATION=false"},
+			expFlags: &Flags{},
+		},
+		{
+			name:       "invalid value",
+			environ:    []string{"ENABLE_LAST_RECONCILE_ANNOTATION=yes-please"},
+			expFlags:   &Flags{},
+			expWarning: "Ignoring invalid feature flag value",
+		},
+		{
+			name:       "unknown flag",
+			environ:    []string{"ENABLE_LAST_RECONCILE_ANOTATION=true"},
+			expFlags:   &Flags{},
+			expWarning: "Ignoring unknown feature flag",
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		logger, buf := newBufferLogger()
+		assert.Equal(t, tc.expFlags, Load(tc.environ, logger))
+		if tc.expWarning != "" {
+			assert.Contains(t, buf.String(), tc.expWarning)
+		} else {
+			assert.NotContains(t, buf.String(), "Ignoring")
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, []string{"ENABLE_BOOT_ID_RELABEL", "ENABLE_DEV_INSTANCE_LIST_FILE", "ENABLE_INSTANCE_PROFILE_LABELS", "ENABLE_LAST_RECONCILE_ANNOTATION", "ENABLE_LOWERCASE_TOPOLOGY", "ENABLE_NODE_CONDITION", "ENABLE_POD_ANNOTATIONS", "ENABLE_VPC_ID_LABEL_REQUIRED"}, Names())
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// auditDefaultActor identifies the updater in audit records when its pod is unknown.
+	auditDefaultActor   = "vpc-node-label-updater"
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+	// auditOutcomeDryRun marks a successful action in dry-run mode, which wrote nothing.
+	auditOutcomeDryRun = "dry-run"
+)
+
+var (
+	// auditURLPattern matches the URLs embedded in error messages.
+	auditURLPattern = regexp.MustCompile(`https?://[^\s"']+`)
+	// auditBearerPattern matches bearer tokens embedded in error messages.
+	auditBearerPattern = regexp.MustCompile(`(?i)bearer\s+[^\s"']+`)
+)
+
+// audit emits a single structured audit record, marked with audit=true, for a node label action.
+// Labels holds the labels the action set or attempted to set; in dry-run mode those it would have set.
+func (c *VpcNodeLabelUpdater) audit(workerNodeName string, nodeinfo *NodeInfo, labels map[string]string, err error) {
+	outcome, labelsField := auditOutcomeSuccess, "labelsChanged"
+	if c.getConfig().DryRun {
+		outcome, labelsField = auditOutcomeDryRun, "labelsToChange"
+	}
+	if err != nil {
+		outcome = auditOutcomeFailure
+	}
+	fields := []zap.Field{
+		zap.Bool("audit", true),
+		zap.String("actor", c.getAuditActor()),
+		zap.String("node", workerNodeName),
+		zap.String("outcome", outcome),
+	}
+	if nodeinfo != nil {
+		fields = append(fields, zap.String("instanceID", nodeinfo.InstanceID))
+	}
+	if len(labels) > 0 {
+		fields = append(fields, zap.Any(labelsField, labels))
+	}
+	if err != nil {
+		fields = append(fields, zap.String("error", redactSecrets(err.Error())))
+	}
+	c.Logger.Named("audit").Info("Node label action", fields...)
+}
+
+// getAuditActor returns the namespaced name of the updater pod, or a default actor if it is unknown.
+func (c *VpcNodeLabelUpdater) getAuditActor() string {
+	cfg := c.getConfig()
+	if cfg.PodName == "" || cfg.PodNamespace == "" {
+		return auditDefaultActor
+	}
+	return cfg.PodNamespace + "/" + cfg.PodName
+}
+
+// redactSecrets removes bearer tokens and the credentials of embedded URLs from msg.
+func redactSecrets(msg string) string {
+	msg = auditURLPattern.ReplaceAllStringFunc(msg, redactURL)
+	return auditBearerPattern.ReplaceAllString(msg, "Bearer "+redactedValue)
+}
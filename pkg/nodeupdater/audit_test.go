@@ -0,0 +1,87 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getAuditRecords returns the audit records logged in buf.
+func getAuditRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		record := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(line, &record))
+		if record["audit"] == true {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestUpdateNodeLabelAudit(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	logger, buf := newBufferLogger()
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{topologyZoneLabelKey: "us-south-1"}), riaas.URL)
+	updater.Logger = logger
+	updater.Config = &Config{PodName: "updater-abcde", PodNamespace: "kube-system"}
+	_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+
+	records := getAuditRecords(t, buf)
+	assert.Equal(t, 1, len(records))
+	record := records[0]
+	assert.Equal(t, "audit", record["logger"])
+	assert.Equal(t, "kube-system/updater-abcde", record["actor"])
+	assert.Equal(t, "valid-worker", record["node"])
+	assert.Equal(t, "valid-instance-id", record["instanceID"])
+	assert.Equal(t, auditOutcomeSuccess, record["outcome"])
+	assert.NotContains(t, record, "error")
+	changed, ok := record["labelsChanged"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "valid-instance-id", changed[instanceIDLabelKey])
+	assert.NotContains(t, changed, topologyZoneLabelKey)
+}
+
+func TestAuditRedactsSecrets(t *testing.T) {
+	logger, buf := newBufferLogger()
+	updater := &VpcNodeLabelUpdater{Logger: logger}
+
+	err := errors.New(`Get "https://us-south.iaas.cloud.ibm.com/v1/instances?token=secret-token&name=valid-worker": Authorization: Bearer secret-bearer rejected`)
+	updater.audit("valid-worker", nil, nil, err)
+
+	records := getAuditRecords(t, buf)
+	assert.Equal(t, 1, len(records))
+	record := records[0]
+	assert.Equal(t, auditDefaultActor, record["actor"])
+	assert.Equal(t, auditOutcomeFailure, record["outcome"])
+	assert.NotContains(t, record, "instanceID")
+	assert.NotContains(t, record, "labelsChanged")
+	assert.Contains(t, record["error"], "name=valid-worker")
+	assert.NotContains(t, record["error"], "secret-token")
+	assert.NotContains(t, record["error"], "secret-bearer")
+	assert.NotContains(t, buf.String(), "secret-")
+}
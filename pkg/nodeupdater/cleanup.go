@@ -0,0 +1,99 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// getAppliedLabelKeys returns the label keys recorded on the node as applied by the updater, sorted.
+func getAppliedLabelKeys(node *v1.Node) []string {
+	value := node.ObjectMeta.Annotations[managedKeysAnnotationKey]
+	if value == "" {
+		return nil
+	}
+	keys := strings.Split(value, ",")
+	sort.Strings(keys)
+	return keys
+}
+
+// recordAppliedLabelKeys adds the keys of labels to the applied label keys recorded on the node. Keys
+// recorded by earlier updates are kept, so that cleanup also removes labels no longer computed.
+func recordAppliedLabelKeys(node *v1.Node, labels map[string]string) {
+	keys := map[string]bool{}
+	for _, key := range getAppliedLabelKeys(node) {
+		keys[key] = true
+	}
+	for key := range labels {
+		keys[key] = true
+	}
+	applied := make([]string, 0, len(keys))
+	for key := range keys {
+		applied = append(applied, key)
+	}
+	sort.Strings(applied)
+	if node.ObjectMeta.Annotations == nil {
+		node.ObjectMeta.Annotations = make(map[string]string)
+	}
+	node.ObjectMeta.Annotations[managedKeysAnnotationKey] = strings.Join(applied, ",")
+}
+
+// CleanupManagedLabels removes from the configured node exactly the label keys recorded as applied
+// by the updater, along with the record itself, and returns the removed keys. Labels the updater
+// did not record are left alone. In dry run the keys are only logged.
+func CleanupManagedLabels(ctx context.Context, k8sClient kubernetes.Interface, cfg *Config, logger *zap.Logger) ([]string, error) {
+	var removed []string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := k8sClient.CoreV1().Nodes().Get(ctx, cfg.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		removed = nil
+		for _, key := range getAppliedLabelKeys(node) {
+			if _, ok := node.ObjectMeta.Labels[key]; ok {
+				removed = append(removed, key)
+			}
+		}
+		if _, ok := node.ObjectMeta.Annotations[managedKeysAnnotationKey]; !ok {
+			logger.Info("Node has no record of applied labels, nothing to clean up", zap.String("nodeName", cfg.NodeName))
+			return nil
+		}
+		if cfg.DryRun {
+			logger.Info("Dry run, not removing labels from the node", zap.String("nodeName", cfg.NodeName), zap.Strings("labels", removed))
+			return nil
+		}
+		for _, key := range removed {
+			delete(node.ObjectMeta.Labels, key)
+		}
+		delete(node.ObjectMeta.Annotations, managedKeysAnnotationKey)
+		_, err = k8sClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
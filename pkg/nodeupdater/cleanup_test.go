@@ -0,0 +1,65 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCleanupManagedLabels(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	// Labels set by others are kept by the round trip.
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{"team": "storage"}), riaas.URL)
+	_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	applied := getAppliedLabelKeys(node)
+	assert.Contains(t, applied, instanceIDLabelKey)
+	assert.Contains(t, applied, topologyZoneLabelKey)
+	assert.NotContains(t, applied, "team")
+
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+
+	// Dry run leaves the node unchanged.
+	removed, err := CleanupManagedLabels(context.TODO(), clientset, &Config{NodeName: "valid-worker", DryRun: true}, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, applied, removed)
+	node, _ = clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Equal(t, "valid-instance-id", node.Labels[instanceIDLabelKey])
+
+	removed, err = CleanupManagedLabels(context.TODO(), clientset, &Config{NodeName: "valid-worker"}, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, applied, removed)
+	node, err = clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "storage"}, node.Labels)
+	assert.NotContains(t, node.Annotations, managedKeysAnnotationKey)
+
+	// A second cleanup finds nothing to remove.
+	removed, err = CleanupManagedLabels(context.TODO(), clientset, &Config{NodeName: "valid-worker"}, logger)
+	assert.Nil(t, err)
+	assert.Empty(t, removed)
+}
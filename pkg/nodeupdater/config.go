@@ -0,0 +1,751 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	secretutils "github.com/IBM/secret-utils-lib/pkg/utils"
+	"github.com/IBM/vpc-node-label-updater/pkg/features"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	redactedValue = "REDACTED"
+
+	defaultSecretProviderTimeout = 2 * time.Minute
+	// defaultIAMServiceName is the service name the IAM token is requested for.
+	defaultIAMServiceName = "vpc-node-label-updater"
+	// defaultCRTokenFile is where IKS projects the compute resource token used with trusted profiles.
+	defaultCRTokenFile = "/var/run/secrets/tokens/vault-token"
+	// defaultAuthScheme is the scheme of the Authorization header sent to RIAAS.
+	defaultAuthScheme = "Bearer"
+	// defaultProvisioningWaitTimeout keeps the wait for a provisioning instance short.
+	defaultProvisioningWaitTimeout = 30 * time.Second
+	// minPageLimit and maxPageLimit are the bounds of the RIAAS list limit parameter.
+	minPageLimit = 1
+	maxPageLimit = 100
+	// defaultMaxLabels caps the labels set in one run, well above the labels the updater computes.
+	defaultMaxLabels = 64
+	maxMaxLabels     = 1024
+	// maxK8sBurst bounds the kubernetes client burst.
+	maxK8sBurst = 10000
+
+	// Error classes with their own retry budgets.
+	errorClassConnection = "connection"
+	errorClassRateLimit  = "rate-limit"
+	errorClassServer     = "server"
+
+	// NodeNameTransformStripDomain strips everything from the first dot of the node name.
+	NodeNameTransformStripDomain = "strip-domain"
+	// NodeNameTransformLowercase lowercases the node name.
+	NodeNameTransformLowercase = "lowercase"
+
+	// IAMAuthModeDefault obtains the IAM token with the credentials of the secret provider.
+	IAMAuthModeDefault = "default"
+	// IAMAuthModeTrustedProfile exchanges a compute resource token for a trusted profile IAM token.
+	IAMAuthModeTrustedProfile = "trusted-profile"
+
+	// RedirectAuthRefuse refuses RIAAS redirects to another host.
+	RedirectAuthRefuse = "refuse"
+	// RedirectAuthPreserve follows RIAAS redirects to another host, keeping the Authorization header.
+	RedirectAuthPreserve = "preserve"
+
+	// LabelValueOverflowTruncate truncates over-length label values to the maximum allowed length.
+	LabelValueOverflowTruncate = "truncate"
+	// LabelValueOverflowError fails the update when a label value is over-length.
+	LabelValueOverflowError = "error"
+
+	// MissingZoneFail fails the update when the matched instance has no zone.
+	MissingZoneFail = "fail"
+	// MissingZoneSkipTopology sets only the non-topology labels when the matched instance has no zone.
+	MissingZoneSkipTopology = "skip-topology"
+	// MissingZoneUseOverride uses the configured zone override when the matched instance has no zone.
+	MissingZoneUseOverride = "use-override"
+
+	// TopologyConflictOverwrite overwrites existing topology labels with the computed values.
+	TopologyConflictOverwrite = "overwrite"
+	// TopologyConflictSkipIfPresent keeps the topology labels already set on the node.
+	TopologyConflictSkipIfPresent = "skip-if-present"
+	// TopologyConflictErrorOnMismatch fails the update when an existing topology label differs from the computed value.
+	TopologyConflictErrorOnMismatch = "error-on-mismatch"
+
+	// ProviderIDMismatchWarn logs a warning when the instance ID differs from the one in the node's provider ID.
+	ProviderIDMismatchWarn = "warn"
+	// ProviderIDMismatchFail fails the update when the instance ID differs from the one in the node's provider ID.
+	ProviderIDMismatchFail = "fail"
+
+	// ProvisioningWait waits for a provisioning instance to be running before labeling the node.
+	ProvisioningWait = "wait"
+	// ProvisioningProceed labels the node with the details of a provisioning instance as they are.
+	ProvisioningProceed = "proceed"
+)
+
+// sensitiveQueryKeys are query parameter name fragments whose values are never logged.
+var sensitiveQueryKeys = []string{"token", "key", "secret", "password", "signature"}
+
+// Config holds the effective configuration of the updater.
+type Config struct {
+	NodeName       string
+	PodName        string
+	PodNamespace   string
+	WebhookURL     string
+	WebhookTimeout time.Duration
+	MaxAttempts    int
+	RetryInterval  string
+	RiaasEndpoint  string
+	// RetryAttempts caps the attempts per error class, zero for the class default.
+	RetryAttempts map[string]int
+	// MaxLabels caps the number of labels set in one run, zero for the default.
+	MaxLabels int
+	// LabelValueOverflow is the policy applied to label values over 63 characters.
+	LabelValueOverflow string
+	// AnnotateLastReconcile records the time of the last successful label update on the node.
+	AnnotateLastReconcile bool
+	// OptIn only labels nodes annotated with vpc-node-label-updater/enabled=true.
+	OptIn bool
+	// DryRun logs the labels that would be set on the node instead of writing them.
+	DryRun bool
+	// InstanceProfileLabels also labels the node with the bandwidth of its instance profile, when known.
+	// The profile itself is always labeled.
+	InstanceProfileLabels bool
+	// RequireVpcIDLabel treats a node without the VPC ID label as not yet labeled, so that existing nodes get it.
+	RequireVpcIDLabel bool
+	// RelabelOnBootIDChange relabels the node when its boot ID differs from the one recorded at the last update.
+	RelabelOnBootIDChange bool
+	// SetNodeCondition sets the VPCLabelsApplied condition on the node status after a successful update.
+	SetNodeCondition bool
+	// AnnotatePod records the discovered zone and region on the updater's own pod.
+	AnnotatePod bool
+	// LowercaseTopology lowercases the region and zone label values.
+	LowercaseTopology bool
+	// MissingZonePolicy controls labeling when the matched instance has no zone.
+	MissingZonePolicy string
+	// TopologyConflictPolicy controls labeling when an existing topology label differs from the computed value.
+	TopologyConflictPolicy string
+	// ProviderIDMismatchPolicy controls labeling when the instance ID differs from the one in the node's provider ID.
+	ProviderIDMismatchPolicy string
+	// ProvisioningPolicy controls labeling when the matched instance is still provisioning.
+	ProvisioningPolicy string
+	// ProvisioningWaitTimeout bounds the wait for a provisioning instance, zero for the default.
+	ProvisioningWaitTimeout time.Duration
+	// RegionFromZoneRegex extracts the region from a zone name with its first capture group,
+	// empty to trim the zone after its last hyphen.
+	RegionFromZoneRegex string
+	// ZoneOverride is the zone used with the use-override missing zone policy.
+	ZoneOverride string
+	// CredentialKeys are the secret keys a node annotation may select credentials from.
+	CredentialKeys []string
+	// NodeNameTransforms are applied in order to the node name before looking up its instance.
+	NodeNameTransforms []string
+	// IPMatchCIDRs are the subnets within which a node IP falls back to matching the only instance with a
+	// primary IP in the same subnet, for NAT setups where the node IP differs from the VPC primary IP.
+	IPMatchCIDRs []string
+	// MatchHostname matches the node name and its kubernetes.io/hostname label against the instance hostname
+	// and primary network interface name before looking the instance up by name or IP. Without it the
+	// hostname is only matched when no instance has that name or IP.
+	MatchHostname bool
+	// InstanceIDLabelKey overrides the label key carrying the VPC instance ID.
+	InstanceIDLabelKey string
+	// LabelKeys maps the default key of the other managed labels to its override, see labelKeyEnvs.
+	LabelKeys map[string]string
+	// IAMAuthMode selects how the IAM token is obtained.
+	IAMAuthMode string
+	// IAMServiceName is the service name the IAM token is requested for, empty for the default.
+	IAMServiceName string
+	// TrustedProfileID is the trusted profile used with the trusted-profile IAM auth mode.
+	TrustedProfileID string
+	// TrustedProfileIAMURL is the IAM endpoint the compute resource token is exchanged at, empty for the private endpoint.
+	TrustedProfileIAMURL string
+	// TrustedProfileCRTokenFile is the projected compute resource token, empty for the IKS default path.
+	TrustedProfileCRTokenFile string
+	// MetricsAddr is the address the metrics are served on, empty to disable the metrics server.
+	MetricsAddr string
+	// SecretProviderTimeout bounds the secret provider initialization.
+	SecretProviderTimeout time.Duration
+	// RiaasPathPrefix is prepended to the RIAAS API path, for gateways such as Satellite's.
+	RiaasPathPrefix string
+	// InstanceListFile is a captured instance list read instead of calling RIAAS, set only with the dev flag.
+	InstanceListFile string
+	// PageLimit is the number of instances requested per RIAAS page, zero for the API default.
+	PageLimit int
+	// DNSServer is the resolver used for the RIAAS host, empty for system resolution.
+	DNSServer string
+	// AuthScheme is the scheme sent verbatim in the RIAAS Authorization header, empty for the default.
+	AuthScheme string
+	// RedirectAuthPolicy controls RIAAS redirects to another host.
+	RedirectAuthPolicy string
+	// SuccessMarker is the path of the file written after the node labels are in place, empty for none.
+	SuccessMarker string
+	// K8sQPS and K8sBurst throttle the kubernetes client, zero for the client-go defaults.
+	K8sQPS   float32
+	K8sBurst int
+	// TLSClientCert and TLSClientKey are the paths of the client certificate pair for mutual TLS with RIAAS.
+	TLSClientCert string
+	TLSClientKey  string
+}
+
+// LoadConfig reads the updater configuration from the environment.
+func LoadConfig(logger *zap.Logger) *Config {
+	flags := features.Load(os.Environ(), logger)
+	return &Config{
+		NodeName:       os.Getenv("NODE_NAME"),
+		PodName:        os.Getenv("POD_NAME"),
+		PodNamespace:   os.Getenv("POD_NAMESPACE"),
+		WebhookURL:     os.Getenv("WEBHOOK_URL"),
+		WebhookTimeout: getDurationEnv("WEBHOOK_TIMEOUT", logger),
+		MaxAttempts:    getMaxAttemptsEnv(logger),
+		RetryInterval:  getRetryIntervalEnv(logger),
+		RetryAttempts: map[string]int{
+			errorClassConnection: getIntEnv("RETRY_ATTEMPTS_CONNECTION", 1, maxRetryMaxAttempts, logger),
+			errorClassRateLimit:  getIntEnv("RETRY_ATTEMPTS_RATE_LIMIT", 1, maxRetryMaxAttempts, logger),
+			errorClassServer:     getIntEnv("RETRY_ATTEMPTS_SERVER", 1, maxRetryMaxAttempts, logger),
+		},
+		MaxLabels: getIntEnv("MAX_LABELS", 1, maxMaxLabels, logger),
+		LabelValueOverflow: getEnumEnv("LABEL_VALUE_OVERFLOW", LabelValueOverflowTruncate, logger,
+			LabelValueOverflowTruncate, LabelValueOverflowError),
+		AnnotateLastReconcile: flags.LastReconcileAnnotation,
+		RelabelOnBootIDChange: flags.BootIDRelabel,
+		InstanceProfileLabels: flags.InstanceProfileLabels,
+		RequireVpcIDLabel:     flags.VpcIDLabelRequired,
+		SetNodeCondition:      flags.NodeCondition,
+		AnnotatePod:           flags.PodAnnotations,
+		LowercaseTopology:     flags.LowercaseTopology,
+		MissingZonePolicy: getEnumEnv("MISSING_ZONE_POLICY", MissingZoneFail, logger,
+			MissingZoneFail, MissingZoneSkipTopology, MissingZoneUseOverride),
+		TopologyConflictPolicy: getEnumEnv("TOPOLOGY_CONFLICT_POLICY", TopologyConflictOverwrite, logger,
+			TopologyConflictOverwrite, TopologyConflictSkipIfPresent, TopologyConflictErrorOnMismatch),
+		ProviderIDMismatchPolicy: getEnumEnv("PROVIDER_ID_MISMATCH_POLICY", ProviderIDMismatchWarn, logger,
+			ProviderIDMismatchWarn, ProviderIDMismatchFail),
+		ProvisioningPolicy: getEnumEnv("PROVISIONING_POLICY", ProvisioningWait, logger,
+			ProvisioningWait, ProvisioningProceed),
+		ProvisioningWaitTimeout: getDurationEnv("PROVISIONING_WAIT_TIMEOUT", logger),
+		RegionFromZoneRegex:     os.Getenv("REGION_FROM_ZONE_REGEX"),
+		ZoneOverride:            os.Getenv("ZONE_OVERRIDE"),
+		CredentialKeys:          getListEnv("CREDENTIAL_KEYS"),
+		NodeNameTransforms: getEnumListEnv("NODE_NAME_TRANSFORM", logger,
+			NodeNameTransformStripDomain, NodeNameTransformLowercase),
+		MatchHostname:      getBoolEnv("MATCH_HOSTNAME", logger),
+		IPMatchCIDRs:       getListEnv("IP_MATCH_CIDRS"),
+		DryRun:             getBoolEnv("DRY_RUN", logger),
+		OptIn:              getBoolEnv("OPT_IN_ANNOTATION", logger),
+		InstanceIDLabelKey: strings.TrimSpace(os.Getenv("INSTANCE_ID_LABEL_KEY")),
+		LabelKeys:          getLabelKeysEnv(),
+		IAMAuthMode: getEnumEnv("IAM_AUTH_MODE", IAMAuthModeDefault, logger,
+			IAMAuthModeDefault, IAMAuthModeTrustedProfile),
+		IAMServiceName:            os.Getenv("IAM_SERVICE_NAME"),
+		TrustedProfileID:          os.Getenv("TRUSTED_PROFILE_ID"),
+		TrustedProfileIAMURL:      strings.TrimSpace(os.Getenv("TRUSTED_PROFILE_IAM_URL")),
+		TrustedProfileCRTokenFile: strings.TrimSpace(os.Getenv("TRUSTED_PROFILE_CR_TOKEN_FILE")),
+		MetricsAddr:               strings.TrimSpace(os.Getenv("METRICS_ADDR")),
+		SecretProviderTimeout:     getDurationEnv("SECRET_PROVIDER_TIMEOUT", logger),
+		RiaasPathPrefix:           os.Getenv("RIAAS_PATH_PREFIX"),
+		InstanceListFile:          getInstanceListFileEnv(flags.DevInstanceListFile, logger),
+		PageLimit:                 getIntEnv("RIAAS_PAGE_LIMIT", minPageLimit, maxPageLimit, logger),
+		DNSServer:                 os.Getenv("RIAAS_DNS_SERVER"),
+		AuthScheme:                strings.TrimSpace(os.Getenv("RIAAS_AUTH_SCHEME")),
+		RedirectAuthPolicy: getEnumEnv("RIAAS_REDIRECT_AUTH", RedirectAuthRefuse, logger,
+			RedirectAuthRefuse, RedirectAuthPreserve),
+		TLSClientCert: os.Getenv("RIAAS_TLS_CLIENT_CERT"),
+		TLSClientKey:  os.Getenv("RIAAS_TLS_CLIENT_KEY"),
+		SuccessMarker: os.Getenv("SUCCESS_MARKER"),
+		K8sQPS:        getPositiveFloatEnv("K8S_QPS", logger),
+		K8sBurst:      getIntEnv("K8S_BURST", 1, maxK8sBurst, logger),
+	}
+}
+
+// Validate checks that the configuration required at startup is present, returning a single
+// error listing every missing setting.
+func (cfg *Config) Validate() error {
+	var missing []string
+	if cfg.NodeName == "" && (cfg.PodName == "" || cfg.PodNamespace == "") {
+		missing = append(missing, "NODE_NAME, or POD_NAME and POD_NAMESPACE")
+	}
+	if cfg.MissingZonePolicy == MissingZoneUseOverride && cfg.ZoneOverride == "" {
+		missing = append(missing, "ZONE_OVERRIDE for MISSING_ZONE_POLICY="+MissingZoneUseOverride)
+	}
+	if cfg.IAMAuthMode == IAMAuthModeTrustedProfile && cfg.TrustedProfileID == "" {
+		missing = append(missing, "TRUSTED_PROFILE_ID for IAM_AUTH_MODE="+IAMAuthModeTrustedProfile)
+	}
+	if cfg.TLSClientCert != "" && cfg.TLSClientKey == "" {
+		missing = append(missing, "RIAAS_TLS_CLIENT_KEY for RIAAS_TLS_CLIENT_CERT")
+	}
+	if cfg.TLSClientKey != "" && cfg.TLSClientCert == "" {
+		missing = append(missing, "RIAAS_TLS_CLIENT_CERT for RIAAS_TLS_CLIENT_KEY")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing required configuration: %s", ErrInvalidConfig, strings.Join(missing, "; "))
+	}
+	if _, err := cfg.getRegionFromZoneRegex(); err != nil {
+		return err
+	}
+	if _, err := cfg.getIPMatchNetworks(); err != nil {
+		return err
+	}
+	if err := cfg.ValidateLabelKeys(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(cfg.AuthScheme, " \t") {
+		return fmt.Errorf("%w: invalid RIAAS_AUTH_SCHEME %q: must be a single token", ErrInvalidConfig, cfg.AuthScheme)
+	}
+	return nil
+}
+
+// LogEffectiveConfig logs a single line summarizing the configuration, with secrets redacted.
+func (cfg *Config) LogEffectiveConfig(logger *zap.Logger) {
+	logger.Info("Effective configuration", cfg.effectiveConfigFields()...)
+}
+
+// LogRIAASEndpoint logs the RIAAS endpoint read from the secret configuration after the effective
+// configuration was logged, redacted the same way.
+func (cfg *Config) LogRIAASEndpoint(logger *zap.Logger) {
+	logger.Info("Using RIAAS endpoint from the secret configuration", zap.String("riaasEndpoint", redactURL(cfg.RiaasEndpoint)))
+}
+
+// effectiveConfigFields returns the configuration as log fields, with secrets redacted.
+func (cfg *Config) effectiveConfigFields() []zap.Field {
+	return []zap.Field{
+		zap.String("nodeName", cfg.NodeName),
+		zap.String("riaasEndpoint", redactURL(cfg.RiaasEndpoint)),
+		zap.String("webhookURL", redactURL(cfg.WebhookURL)),
+		zap.Duration("webhookTimeout", cfg.WebhookTimeout),
+		zap.Int("maxAttempts", cfg.GetMaxAttempts()),
+		zap.String("retryInterval", cfg.RetryInterval),
+		zap.Int("retryAttemptsConnection", cfg.GetRetryAttempts(errorClassConnection)),
+		zap.Int("retryAttemptsRateLimit", cfg.GetRetryAttempts(errorClassRateLimit)),
+		zap.Int("retryAttemptsServer", cfg.GetRetryAttempts(errorClassServer)),
+		zap.Int("maxLabels", cfg.GetMaxLabels()),
+		zap.String("labelValueOverflow", cfg.LabelValueOverflow),
+		zap.Bool("dryRun", cfg.DryRun),
+		zap.Bool("optIn", cfg.OptIn),
+		zap.Bool("annotateLastReconcile", cfg.AnnotateLastReconcile),
+		zap.Bool("relabelOnBootIDChange", cfg.RelabelOnBootIDChange),
+		zap.Bool("instanceProfileLabels", cfg.InstanceProfileLabels),
+		zap.Bool("requireVpcIDLabel", cfg.RequireVpcIDLabel),
+		zap.Bool("setNodeCondition", cfg.SetNodeCondition),
+		zap.Bool("annotatePod", cfg.AnnotatePod),
+		zap.Bool("lowercaseTopology", cfg.LowercaseTopology),
+		zap.String("missingZonePolicy", cfg.MissingZonePolicy),
+		zap.String("topologyConflictPolicy", cfg.TopologyConflictPolicy),
+		zap.String("providerIDMismatchPolicy", cfg.ProviderIDMismatchPolicy),
+		zap.String("provisioningPolicy", cfg.ProvisioningPolicy),
+		zap.Duration("provisioningWaitTimeout", cfg.GetProvisioningWaitTimeout()),
+		zap.String("regionFromZoneRegex", cfg.RegionFromZoneRegex),
+		zap.String("zoneOverride", cfg.ZoneOverride),
+		zap.Strings("credentialKeys", cfg.CredentialKeys),
+		zap.Strings("nodeNameTransforms", cfg.NodeNameTransforms),
+		zap.Bool("matchHostname", cfg.MatchHostname),
+		zap.Strings("ipMatchCIDRs", cfg.IPMatchCIDRs),
+		zap.String("instanceIDLabelKey", cfg.GetInstanceIDLabelKey()),
+		zap.Any("labelKeys", cfg.LabelKeys),
+		zap.String("iamAuthMode", cfg.IAMAuthMode),
+		zap.String("iamServiceName", cfg.GetIAMServiceName()),
+		zap.String("trustedProfileID", cfg.TrustedProfileID),
+		zap.String("trustedProfileIAMURL", cfg.GetTrustedProfileIAMURL()),
+		zap.String("trustedProfileCRTokenFile", cfg.GetTrustedProfileCRTokenFile()),
+		zap.String("metricsAddr", cfg.MetricsAddr),
+		zap.Duration("secretProviderTimeout", cfg.GetSecretProviderTimeout()),
+		zap.String("riaasPathPrefix", cfg.RiaasPathPrefix),
+		zap.String("instanceListFile", cfg.InstanceListFile),
+		zap.Int("pageLimit", cfg.PageLimit),
+		zap.String("dnsServer", cfg.DNSServer),
+		zap.String("authScheme", cfg.GetAuthScheme()),
+		zap.String("redirectAuthPolicy", cfg.RedirectAuthPolicy),
+		zap.String("tlsClientCert", cfg.TLSClientCert),
+		zap.String("successMarker", cfg.SuccessMarker),
+		zap.Float32("k8sQPS", cfg.K8sQPS),
+		zap.Int("k8sBurst", cfg.K8sBurst),
+	}
+}
+
+// GetInstanceIDLabelKey returns the label key carrying the VPC instance ID.
+func (cfg *Config) GetInstanceIDLabelKey() string {
+	if cfg.InstanceIDLabelKey == "" {
+		return instanceIDLabelKey
+	}
+	return cfg.InstanceIDLabelKey
+}
+
+// labelKey returns the configured key of the managed label with the given default key.
+func (cfg *Config) labelKey(defaultKey string) string {
+	if defaultKey == instanceIDLabelKey {
+		return cfg.GetInstanceIDLabelKey()
+	}
+	if key := cfg.LabelKeys[defaultKey]; key != "" {
+		return key
+	}
+	return defaultKey
+}
+
+// ValidateLabelKeys checks that the overridden label keys are valid kubernetes label keys and that no
+// two managed labels share a key. It is all the validation the snapshot subcommand needs.
+func (cfg *Config) ValidateLabelKeys() error {
+	overrides := map[string]string{"INSTANCE_ID_LABEL_KEY": cfg.InstanceIDLabelKey}
+	for env, defaultKey := range labelKeyEnvs {
+		overrides[env] = cfg.LabelKeys[defaultKey]
+	}
+	envs := make([]string, 0, len(overrides))
+	for env := range overrides {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		key := overrides[env]
+		if key == "" {
+			continue
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("%w: invalid %s %q: %s", ErrInvalidConfig, env, key, strings.Join(errs, "; "))
+		}
+	}
+	keys := cfg.ManagedLabelKeys()
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			return fmt.Errorf("%w: label key %q is configured for more than one label", ErrInvalidConfig, keys[i])
+		}
+	}
+	return nil
+}
+
+// GetRetryAttempts returns the attempt cap of the given error class. RETRY_ATTEMPTS_<CLASS> takes
+// precedence, bounded like RETRY_MAX_ATTEMPTS and by it, as ErrorRetry makes no more attempts than that.
+// Without it connection errors get RETRY_MAX_ATTEMPTS, while rate-limit and server errors are not retried.
+func (cfg *Config) GetRetryAttempts(class string) int {
+	if attempts := cfg.RetryAttempts[class]; attempts > 0 {
+		if attempts > cfg.GetMaxAttempts() {
+			return cfg.GetMaxAttempts()
+		}
+		return attempts
+	}
+	if class == errorClassConnection {
+		return cfg.GetMaxAttempts()
+	}
+	return 1
+}
+
+// GetMaxAttempts returns the number of attempts made by ErrorRetry.
+func (cfg *Config) GetMaxAttempts() int {
+	if cfg.MaxAttempts <= 0 {
+		return maxAttempts
+	}
+	return cfg.MaxAttempts
+}
+
+// GetRetryInterval returns the interval between the attempts made by ErrorRetry.
+func (cfg *Config) GetRetryInterval(logger *zap.Logger) time.Duration {
+	if cfg.RetryInterval == "" {
+		return defaultRetryInterval
+	}
+	return parseRetryInterval(cfg.RetryInterval, logger)
+}
+
+// ErrorRetry retries funcToRetry like the package ErrorRetry, with the configured attempts and interval.
+func (cfg *Config) ErrorRetry(ctx context.Context, logger *zap.Logger, funcToRetry func() (error, bool)) error {
+	return errorRetry(ctx, logger, cfg.GetMaxAttempts(), cfg.GetRetryInterval(logger), funcToRetry)
+}
+
+// TransformNodeName applies the configured transforms to the node name so it matches the instance
+// name in RIAAS. Node names in IP format are returned unchanged.
+func (cfg *Config) TransformNodeName(nodeName string) string {
+	if net.ParseIP(nodeName) != nil {
+		return nodeName
+	}
+	for _, transform := range cfg.NodeNameTransforms {
+		switch transform {
+		case NodeNameTransformStripDomain:
+			nodeName, _, _ = strings.Cut(nodeName, ".")
+		case NodeNameTransformLowercase:
+			nodeName = strings.ToLower(nodeName)
+		}
+	}
+	return nodeName
+}
+
+// GetMaxLabels returns the maximum number of labels set in one run.
+func (cfg *Config) GetMaxLabels() int {
+	if cfg.MaxLabels <= 0 {
+		return defaultMaxLabels
+	}
+	return cfg.MaxLabels
+}
+
+// GetRegionFromZone derives the region from a zone name using the configured regex, falling back
+// to trimming the zone after its last hyphen when no regex is set or it does not match.
+func (cfg *Config) GetRegionFromZone(zone string) string {
+	re, err := cfg.getRegionFromZoneRegex()
+	if err == nil && re != nil {
+		if match := re.FindStringSubmatch(zone); match != nil {
+			return match[1]
+		}
+	}
+	return getRegionFromZone(zone)
+}
+
+// getIPMatchNetworks parses the configured IP match subnets.
+func (cfg *Config) getIPMatchNetworks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cfg.IPMatchCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid IP_MATCH_CIDRS: %v", ErrInvalidConfig, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// getRegionFromZoneRegex compiles the configured region regex, returning nil if none is set.
+func (cfg *Config) getRegionFromZoneRegex() (*regexp.Regexp, error) {
+	if cfg.RegionFromZoneRegex == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(cfg.RegionFromZoneRegex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid REGION_FROM_ZONE_REGEX: %v", ErrInvalidConfig, err)
+	}
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("%w: invalid REGION_FROM_ZONE_REGEX: %q has no capture group for the region", ErrInvalidConfig, cfg.RegionFromZoneRegex)
+	}
+	return re, nil
+}
+
+// GetIAMServiceName returns the service name the IAM token is requested for.
+func (cfg *Config) GetIAMServiceName() string {
+	if cfg.IAMServiceName == "" {
+		return defaultIAMServiceName
+	}
+	return cfg.IAMServiceName
+}
+
+// GetTrustedProfileIAMURL returns the IAM endpoint the compute resource token is exchanged at.
+func (cfg *Config) GetTrustedProfileIAMURL() string {
+	if cfg.TrustedProfileIAMURL == "" {
+		return secretutils.ProdPrivateIAMURL
+	}
+	return cfg.TrustedProfileIAMURL
+}
+
+// GetTrustedProfileCRTokenFile returns the file holding the compute resource token of the pod.
+func (cfg *Config) GetTrustedProfileCRTokenFile() string {
+	if cfg.TrustedProfileCRTokenFile == "" {
+		return defaultCRTokenFile
+	}
+	return cfg.TrustedProfileCRTokenFile
+}
+
+// GetAuthScheme returns the scheme of the Authorization header sent to RIAAS.
+func (cfg *Config) GetAuthScheme() string {
+	if cfg.AuthScheme == "" {
+		return defaultAuthScheme
+	}
+	return cfg.AuthScheme
+}
+
+// GetSecretProviderTimeout returns the timeout for the secret provider initialization.
+func (cfg *Config) GetSecretProviderTimeout() time.Duration {
+	if cfg.SecretProviderTimeout <= 0 {
+		return defaultSecretProviderTimeout
+	}
+	return cfg.SecretProviderTimeout
+}
+
+// GetProvisioningWaitTimeout returns how long to wait for a provisioning instance to be running.
+func (cfg *Config) GetProvisioningWaitTimeout() time.Duration {
+	if cfg.ProvisioningWaitTimeout <= 0 {
+		return defaultProvisioningWaitTimeout
+	}
+	return cfg.ProvisioningWaitTimeout
+}
+
+// redactURL removes credentials and sensitive query values from rawURL so it can be logged.
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+	q := u.Query()
+	for name := range q {
+		for _, sensitive := range sensitiveQueryKeys {
+			if strings.Contains(strings.ToLower(name), sensitive) {
+				q.Set(name, redactedValue)
+				break
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// getMaxAttemptsEnv returns the number of retry attempts set in RETRY_MAX_ATTEMPTS, or maxAttempts if unset or invalid.
+func getMaxAttemptsEnv(logger *zap.Logger) int {
+	if attempts := getIntEnv("RETRY_MAX_ATTEMPTS", 1, maxRetryMaxAttempts, logger); attempts > 0 {
+		return attempts
+	}
+	return maxAttempts
+}
+
+// getRetryIntervalEnv returns the retry interval set in RETRY_INTERVAL, or retryInterval if unset or invalid.
+func getRetryIntervalEnv(logger *zap.Logger) string {
+	value := os.Getenv("RETRY_INTERVAL")
+	if value == "" {
+		return retryInterval
+	}
+	return parseRetryInterval(value, logger).String()
+}
+
+// getDurationEnv parses the duration set in the given environment variable, returning zero if unset or invalid.
+func getDurationEnv(name string, logger *zap.Logger) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("Ignoring invalid duration", zap.String("env", name), zap.String("value", value), zap.Error(err))
+		return 0
+	}
+	return duration
+}
+
+// getEnumEnv returns the value of the given environment variable if it is one of allowed,
+// otherwise defaultValue.
+func getEnumEnv(name, defaultValue string, logger *zap.Logger, allowed ...string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	logger.Warn("Ignoring invalid value, using default", zap.String("env", name), zap.String("value", value), zap.Strings("allowed", allowed), zap.String("default", defaultValue))
+	return defaultValue
+}
+
+// getBoolEnv parses the boolean set in the given environment variable, returning false if unset or invalid.
+func getBoolEnv(name string, logger *zap.Logger) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warn("Ignoring invalid boolean", zap.String("env", name), zap.String("value", value), zap.Error(err))
+		return false
+	}
+	return enabled
+}
+
+// getListEnv returns the non-empty comma-separated values of the given environment variable.
+func getListEnv(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnumListEnv returns the comma-separated values of the given environment variable which are
+// one of allowed, in order.
+func getEnumListEnv(name string, logger *zap.Logger, allowed ...string) []string {
+	var values []string
+	for _, value := range getListEnv(name) {
+		valid := false
+		for _, a := range allowed {
+			if value == a {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			logger.Warn("Ignoring invalid value", zap.String("env", name), zap.String("value", value), zap.Strings("allowed", allowed))
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// getLabelKeysEnv reads the label key overrides set in the labelKeyEnvs variables, keyed by the default key.
+// The keys are validated by Validate so that an invalid key fails fast instead of labeling under the default.
+func getLabelKeysEnv() map[string]string {
+	keys := map[string]string{}
+	for env, defaultKey := range labelKeyEnvs {
+		if value := strings.TrimSpace(os.Getenv(env)); value != "" {
+			keys[defaultKey] = value
+		}
+	}
+	return keys
+}
+
+// getIntEnv parses the integer set in the given environment variable, returning zero if unset, invalid or outside [min, max].
+func getIntEnv(name string, min, max int, logger *zap.Logger) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		logger.Warn("Ignoring invalid integer", zap.String("env", name), zap.String("value", value), zap.Int("min", min), zap.Int("max", max))
+		return 0
+	}
+	return number
+}
+
+// getPositiveFloatEnv parses the number set in the given environment variable, returning zero if unset, invalid or not positive.
+func getPositiveFloatEnv(name string, logger *zap.Logger) float32 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.ParseFloat(value, 32)
+	if err != nil || number <= 0 {
+		logger.Warn("Ignoring invalid positive number", zap.String("env", name), zap.String("value", value))
+		return 0
+	}
+	return float32(number)
+}
+
+// getInstanceListFileEnv returns INSTANCE_LIST_FILE if the dev instance list file flag is enabled, otherwise empty.
+func getInstanceListFileEnv(enabled bool, logger *zap.Logger) string {
+	value := os.Getenv("INSTANCE_LIST_FILE")
+	if value != "" && !enabled {
+		logger.Warn("Ignoring INSTANCE_LIST_FILE, ENABLE_DEV_INSTANCE_LIST_FILE is not set", zap.String("value", value))
+		return ""
+	}
+	return value
+}
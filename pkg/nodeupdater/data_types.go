@@ -27,12 +27,21 @@ type NodeInfo struct {
 	InstanceID string
 	Region     string
 	Zone       string
+	// Status is the lifecycle status of the instance, such as pending or running.
+	Status string
+	// Profile is the instance profile name and Bandwidth its total bandwidth in Mbps, zero if unknown.
+	Profile   string
+	Bandwidth int64
+	// VpcID is the ID of the VPC the instance belongs to, empty if unknown.
+	VpcID string
 }
 
 // StorageSecretConfig ...
 type StorageSecretConfig struct {
 	RiaasEndpointURL *url.URL
 	IAMAccessToken   string
+	// RefreshIAMAccessToken, if set, fetches a fresh IAM token, used when RIAAS rejects IAMAccessToken as expired.
+	RefreshIAMAccessToken func() (string, error)
 }
 
 // AccessTokenResponse ...
@@ -49,12 +58,14 @@ type Instance struct {
 	Href                    string              `json:"href,omitempty"`
 	ID                      string              `json:"id,omitempty"`
 	Name                    string              `json:"name,omitempty"`
+	Hostname                string              `json:"hostname,omitempty"`
 	Memory                  int64               `json:"memory,omitempty"`
 	ResourceGroup           *ResourceGroup      `json:"resource_group,omitempty"`
 	Vcpu                    *Vcpu               `json:"vcpu,omitempty"`
 	Vpc                     *Vpc                `json:"vpc,omitempty"`
 	CreatedAt               *time.Time          `json:"created_at,omitempty"`
 	Status                  string              `json:"status,omitempty"`
+	Bandwidth               *int64              `json:"bandwidth,omitempty"`
 	VolumeAttachments       *[]VolumeAttachment `json:"volume_attachments,omitempty"`
 	NetworkInterfaces       *[]NetworkInterface `json:"network_interfaces,omitempty"`
 	PrimaryNetworkInterface *NetworkInterface   `json:"primary_network_interface,omitempty"`
@@ -158,6 +169,77 @@ type InstanceList struct {
 	TotalCount int         `json:"total_count,omitempty"`
 }
 
+// instanceSummaryList is the lightweight decode target of an instance list.
+type instanceSummaryList struct {
+	Instances []instanceSummary `json:"instances"`
+	Next      *HReference       `json:"next"`
+}
+
+// instanceSummary holds the instance fields used for matching and labeling.
+type instanceSummary struct {
+	ID                      string                     `json:"id"`
+	Name                    string                     `json:"name"`
+	Hostname                string                     `json:"hostname"`
+	CRN                     string                     `json:"crn"`
+	Status                  string                     `json:"status"`
+	Bandwidth               *int64                     `json:"bandwidth"`
+	PrimaryNetworkInterface *networkInterfaceSummary   `json:"primary_network_interface"`
+	NetworkInterfaces       *[]networkInterfaceSummary `json:"network_interfaces"`
+	Zone                    *struct {
+		Name string `json:"name"`
+	} `json:"zone"`
+	Profile *struct {
+		Name string `json:"name"`
+	} `json:"profile"`
+	Vpc *struct {
+		ID string `json:"id"`
+	} `json:"vpc"`
+}
+
+// networkInterfaceSummary holds the network interface fields used for matching.
+type networkInterfaceSummary struct {
+	Name               string `json:"name"`
+	PrimaryIpv4Address string `json:"primary_ipv4_address"`
+}
+
+// toNetworkInterface returns a NetworkInterface carrying the summarized fields.
+func (s *networkInterfaceSummary) toNetworkInterface() NetworkInterface {
+	return NetworkInterface{Name: s.Name, PrimaryIpv4Address: s.PrimaryIpv4Address}
+}
+
+// toInstance returns an Instance carrying the summarized fields.
+func (s *instanceSummary) toInstance() *Instance {
+	instance := &Instance{
+		ID:        s.ID,
+		Name:      s.Name,
+		Hostname:  s.Hostname,
+		CRN:       s.CRN,
+		Status:    s.Status,
+		Bandwidth: s.Bandwidth,
+	}
+	if s.PrimaryNetworkInterface != nil {
+		primary := s.PrimaryNetworkInterface.toNetworkInterface()
+		instance.PrimaryNetworkInterface = &primary
+	}
+	if s.NetworkInterfaces != nil {
+		networkInterfaces := make([]NetworkInterface, 0, len(*s.NetworkInterfaces))
+		for i := range *s.NetworkInterfaces {
+			networkInterfaces = append(networkInterfaces, (*s.NetworkInterfaces)[i].toNetworkInterface())
+		}
+		instance.NetworkInterfaces = &networkInterfaces
+	}
+	if s.Zone != nil {
+		instance.Zone = &Zone{Name: s.Zone.Name}
+	}
+	if s.Profile != nil {
+		instance.Profile = &Profile{Name: s.Profile.Name}
+	}
+	if s.Vpc != nil {
+		instance.Vpc = &Vpc{ID: s.Vpc.ID}
+	}
+	return instance
+}
+
 // HReference ...
 type HReference struct {
 	Href string `json:"href,omitempty"`
@@ -0,0 +1,52 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Diagnostics is the bundle logged with fatal errors, so that a single log line carries
+// what is needed to investigate a failed run. Any field may be unset.
+type Diagnostics struct {
+	NodeName string
+	Config   *Config
+	Updater  *VpcNodeLabelUpdater
+}
+
+// MarshalLogObject ...
+func (d *Diagnostics) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("nodeName", d.NodeName)
+	if d.Config != nil {
+		if err := enc.AddObject("config", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			for _, field := range d.Config.effectiveConfigFields() {
+				field.AddTo(enc)
+			}
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	if d.Updater != nil {
+		enc.AddInt64("riaasAttempts", atomic.LoadInt64(&d.Updater.riaasAttempts))
+		enc.AddInt64("lastRIAASStatus", atomic.LoadInt64(&d.Updater.lastRIAASStatus))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDiagnostics(t *testing.T) {
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer riaas.Close()
+
+	cfg := &Config{
+		NodeName:      "valid-worker",
+		RiaasEndpoint: riaas.URL,
+		WebhookURL:    "https://example.com/hook?token=secret-token",
+	}
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL+"/v1/instances")
+	updater.Config = cfg
+	_, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.NotNil(t, err)
+	_, err = updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.NotNil(t, err)
+
+	// A simulated fatal carries the bundle instead of exiting.
+	logger, buf := newBufferLogger()
+	logger = logger.WithOptions(zap.OnFatal(zapcore.WriteThenPanic))
+	assert.Panics(t, func() {
+		logger.Fatal("Failed to update labels", zap.Error(err), zap.Object("diagnostics", &Diagnostics{NodeName: "valid-worker", Config: cfg, Updater: updater}))
+	})
+	out := buf.String()
+	assert.Contains(t, out, `"diagnostics":{"nodeName":"valid-worker"`)
+	assert.Contains(t, out, `"riaasAttempts":2`)
+	assert.Contains(t, out, `"lastRIAASStatus":503`)
+	assert.Contains(t, out, `"retryAttemptsServer":1`)
+	assert.Contains(t, out, `"riaasEndpoint":"`+riaas.URL+`"`)
+	assert.NotContains(t, out, "secret-token")
+
+	// Fields that are not known yet are left out.
+	buf.Reset()
+	logger.Info("Partial diagnostics", zap.Object("diagnostics", &Diagnostics{NodeName: "valid-worker"}))
+	assert.Contains(t, buf.String(), `"diagnostics":{"nodeName":"valid-worker"}`)
+}
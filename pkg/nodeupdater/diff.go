@@ -0,0 +1,85 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+)
+
+// LabelDiff compares the managed labels desired for a node with the labels it carries.
+type LabelDiff struct {
+	NodeName string `json:"node"`
+	// Missing holds the desired labels the node does not carry.
+	Missing map[string]string `json:"missing"`
+	// Extra holds the managed labels the node carries but which are not desired.
+	Extra map[string]string `json:"extra"`
+	// Mismatched holds the labels the node carries with a value other than the desired one.
+	Mismatched map[string]LabelValues `json:"mismatched"`
+}
+
+// LabelValues are the desired and actual values of a label.
+type LabelValues struct {
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+}
+
+// IsEmpty reports whether the node carries exactly the desired managed labels.
+func (d *LabelDiff) IsEmpty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Mismatched) == 0
+}
+
+// DiffNodeLabels looks up the node's instance and compares the labels an update would set with the
+// labels on c.Node, without making changes. The labels are computed like an update computes them, so
+// the diff fails where an update would.
+func (c *VpcNodeLabelUpdater) DiffNodeLabels(ctx context.Context, workerNodeName string) (*LabelDiff, error) {
+	unlock := c.lockNode()
+	defer unlock()
+	_, desired, err := c.computeNodeLabels(ctx, workerNodeName)
+	if err != nil {
+		return nil, err
+	}
+	return diffLabels(workerNodeName, c.Node.ObjectMeta.Labels, desired, c.getConfig().ManagedLabelKeys()), nil
+}
+
+// diffLabels compares the desired labels with the current ones, reporting managed keys which are
+// set but not desired as extra.
+func diffLabels(nodeName string, current, desired map[string]string, managedKeys []string) *LabelDiff {
+	diff := &LabelDiff{
+		NodeName:   nodeName,
+		Missing:    map[string]string{},
+		Extra:      map[string]string{},
+		Mismatched: map[string]LabelValues{},
+	}
+	for key, value := range desired {
+		actual, ok := current[key]
+		if !ok {
+			diff.Missing[key] = value
+		} else if actual != value {
+			diff.Mismatched[key] = LabelValues{Desired: value, Actual: actual}
+		}
+	}
+	for _, key := range managedKeys {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if actual, ok := current[key]; ok {
+			diff.Extra[key] = actual
+		}
+	}
+	return diff
+}
@@ -0,0 +1,139 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffNodeLabels(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1"),
+		{ID: "zoneless-instance-id", Name: "zoneless-worker", Status: "running"},
+	})
+	defer riaas.Close()
+
+	// A partially labeled node: one label missing, one stale and one no longer managed.
+	node := newTestNode("valid-worker", map[string]string{
+		workerIDLabelKey:      "valid-instance-id",
+		instanceIDLabelKey:    "valid-instance-id",
+		vpcBlockLabelKey:      "true",
+		failureRegionLabelKey: "us-south",
+		failureZoneLabelKey:   "us-south-1",
+		topologyZoneLabelKey:  "us-south-2",
+		"example.com/other":   "value",
+	})
+	updater, clientset := initFakeNodeLabelUpdater(t, node, riaas.URL)
+	updater.Config = &Config{}
+	diff, err := updater.DiffNodeLabels(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, "valid-worker", diff.NodeName)
+	assert.Equal(t, map[string]string{topologyRegionLabelKey: "us-south"}, diff.Missing)
+	assert.Equal(t, map[string]LabelValues{topologyZoneLabelKey: {Desired: "us-south-1", Actual: "us-south-2"}}, diff.Mismatched)
+	assert.Empty(t, diff.Extra)
+	assert.Equal(t, 0, countActions(clientset, "update"))
+
+	// Labels kept by the skip-if-present policy are not differences.
+	updater.Config = &Config{TopologyConflictPolicy: TopologyConflictSkipIfPresent}
+	diff, err = updater.DiffNodeLabels(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Empty(t, diff.Mismatched)
+
+	// Topology labels left on a node whose instance has no zone are extra.
+	zoneless := newTestNode("zoneless-worker", map[string]string{
+		instanceIDLabelKey:   "zoneless-instance-id",
+		topologyZoneLabelKey: "us-south-1",
+	})
+	updater, _ = initFakeNodeLabelUpdater(t, zoneless, riaas.URL)
+	updater.Config = &Config{MissingZonePolicy: MissingZoneSkipTopology}
+	diff, err = updater.DiffNodeLabels(context.TODO(), "zoneless-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{topologyZoneLabelKey: "us-south-1"}, diff.Extra)
+	assert.Equal(t, map[string]string{workerIDLabelKey: "zoneless-instance-id", vpcBlockLabelKey: "true"}, diff.Missing)
+
+	// The missing zone policy fails the diff like it fails an update.
+	updater.Config = &Config{}
+	_, err = updater.DiffNodeLabels(context.TODO(), "zoneless-worker")
+	assert.NotNil(t, err)
+}
+
+func TestDiffNodeLabelsPolicies(t *testing.T) {
+	longID := strings.Repeat("a", 62) + "-bcd"
+	provisioning := newTestInstance("provisioning-worker", "provisioning-instance-id", "us-south-1", "10.0.0.2")
+	provisioning.Status = "pending"
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("long-worker", longID, "us-south-1", "10.0.0.1"),
+		provisioning,
+	})
+	defer riaas.Close()
+	original := provisioningPollInterval
+	defer func() { provisioningPollInterval = original }()
+	provisioningPollInterval = 10 * time.Millisecond
+
+	testCases := []struct {
+		name       string
+		workerName string
+		cfg        *Config
+		expMissing string
+		expErr     bool
+	}{
+		{
+			name:       "truncated label value",
+			workerName: "long-worker",
+			cfg:        &Config{},
+			expMissing: strings.Repeat("a", 62),
+		},
+		{
+			name:       "label value overflow error",
+			workerName: "long-worker",
+			cfg:        &Config{LabelValueOverflow: LabelValueOverflowError},
+			expErr:     true,
+		},
+		{
+			name:       "provisioning instance times out",
+			workerName: "provisioning-worker",
+			cfg:        &Config{ProvisioningWaitTimeout: 30 * time.Millisecond},
+			expErr:     true,
+		},
+		{
+			name:       "provisioning instance labeled as is",
+			workerName: "provisioning-worker",
+			cfg:        &Config{ProvisioningPolicy: ProvisioningProceed},
+			expMissing: "provisioning-instance-id",
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode(tc.workerName, map[string]string{}), riaas.URL)
+		updater.Config = tc.cfg
+		diff, err := updater.DiffNodeLabels(context.TODO(), tc.workerName)
+		assert.Equal(t, 0, countActions(clientset, "update"))
+		if tc.expErr {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expMissing, diff.Missing[instanceIDLabelKey])
+	}
+}
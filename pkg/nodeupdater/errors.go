@@ -0,0 +1,78 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrSecretProviderTimeout is returned when the secret provider does not initialize within the configured timeout.
+var ErrSecretProviderTimeout = errors.New("timed out initializing secret provider")
+
+// ErrInvalidConfig is returned when the configuration is incomplete or invalid.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ErrIAMToken is returned when no IAM access token can be obtained.
+var ErrIAMToken = errors.New("failed to get IAM access token")
+
+// ErrInstanceNotFound is returned when no VPC instance matches the node.
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// ErrEmptyInstanceList is returned when RIAAS lists no instances at all although no filter was applied,
+// which usually means the credentials cannot see the account's instances rather than that the node is missing.
+var ErrEmptyInstanceList = errors.New("vpc provider returned no instances")
+
+// ErrEmptyRIAASEndpoint is returned when the secret provider returns an empty RIAAS endpoint.
+var ErrEmptyRIAASEndpoint = errors.New("secret provider returned an empty RIAAS endpoint")
+
+// ErrInvalidRIAASURL is returned when the RIAAS endpoint cannot be turned into a usable URL.
+type ErrInvalidRIAASURL struct {
+	URL    string
+	Reason string
+	Err    error
+}
+
+// Error ...
+func (e *ErrInvalidRIAASURL) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid RIAAS endpoint URL %q: %v", e.URL, e.Err)
+	}
+	return fmt.Sprintf("invalid RIAAS endpoint URL %q: %s", e.URL, e.Reason)
+}
+
+// Unwrap ...
+func (e *ErrInvalidRIAASURL) Unwrap() error {
+	return e.Err
+}
+
+// ErrRIAASStatus is returned when RIAAS answers with an authentication, rate-limit or server error status.
+type ErrRIAASStatus struct {
+	StatusCode int
+}
+
+// Error ...
+func (e *ErrRIAASStatus) Error() string {
+	return fmt.Sprintf("RIAAS returned unexpected status %d", e.StatusCode)
+}
+
+// IsAuthError reports whether RIAAS rejected the IAM access token.
+func (e *ErrRIAASStatus) IsAuthError() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
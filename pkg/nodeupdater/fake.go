@@ -55,7 +55,7 @@ func GetTestLogger(t *testing.T) (logger *zap.Logger, teardown func()) {
 	logger = zap.New(
 		zapcore.NewCore(
 			zapcore.NewJSONEncoder(encoderCfg),
-			zapcore.AddSync(buf),
+			zapcore.Lock(zapcore.AddSync(buf)),
 			atom,
 		),
 		zap.AddCaller(),
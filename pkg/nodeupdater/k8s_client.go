@@ -0,0 +1,66 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// namespacePath is the service account file holding the namespace the pod runs in.
+const namespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// NewK8sClient builds the kubernetes client from the in-cluster configuration, throttled with the
+// configured QPS and burst.
+func NewK8sClient(cfg *Config) (k8s_utils.KubernetesClient, error) {
+	var kc k8s_utils.KubernetesClient
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return kc, fmt.Errorf("failed to get in-cluster config: %w", err)
+	}
+	cfg.applyClientRateLimits(restConfig)
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return kc, fmt.Errorf("failed to create kubernetes client set: %w", err)
+	}
+	namespace, err := os.ReadFile(namespacePath)
+	if err != nil {
+		return kc, fmt.Errorf("failed to read pod namespace: %w", err)
+	}
+	if len(namespace) == 0 {
+		return kc, fmt.Errorf("failed to read pod namespace: %s is empty", namespacePath)
+	}
+	kc.Clientset = clientset
+	kc.Namespace = string(namespace)
+	return kc, nil
+}
+
+// applyClientRateLimits sets the configured QPS and burst on restConfig, leaving the client-go
+// defaults in place for unset values.
+func (cfg *Config) applyClientRateLimits(restConfig *rest.Config) {
+	if cfg.K8sQPS > 0 {
+		restConfig.QPS = cfg.K8sQPS
+	}
+	if cfg.K8sBurst > 0 {
+		restConfig.Burst = cfg.K8sBurst
+	}
+}
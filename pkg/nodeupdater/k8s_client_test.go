@@ -0,0 +1,45 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+func TestApplyClientRateLimits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      *Config
+		expQPS   float32
+		expBurst int
+	}{
+		{name: "unset keeps defaults", cfg: &Config{}, expQPS: 0, expBurst: 0},
+		{name: "configured values", cfg: &Config{K8sQPS: 50, K8sBurst: 100}, expQPS: 50, expBurst: 100},
+		{name: "fractional qps", cfg: &Config{K8sQPS: 0.5}, expQPS: 0.5, expBurst: 0},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		restConfig := &rest.Config{}
+		tc.cfg.applyClientRateLimits(restConfig)
+		assert.Equal(t, tc.expQPS, restConfig.QPS)
+		assert.Equal(t, tc.expBurst, restConfig.Burst)
+	}
+}
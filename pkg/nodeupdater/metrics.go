@@ -0,0 +1,163 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// metricsPath is where the metrics server serves the metrics.
+	metricsPath = "/metrics"
+	// metricsReadHeaderTimeout bounds how long the metrics server waits for the request headers.
+	metricsReadHeaderTimeout = 10 * time.Second
+)
+
+// Outcomes of a successful label update.
+const (
+	// updateOutcomeApplied is used when the update wrote the labels to the node.
+	updateOutcomeApplied = "applied"
+	// updateOutcomeUnchanged is used when the node already had the labels and only its annotations were refreshed.
+	updateOutcomeUnchanged = "unchanged"
+	// updateOutcomeDryRun is used when the update computed the labels in dry-run mode and wrote nothing.
+	updateOutcomeDryRun = "dry_run"
+)
+
+// updateOutcomes are the outcomes of a successful label update, in the order they are served.
+var updateOutcomes = []string{updateOutcomeApplied, updateOutcomeUnchanged, updateOutcomeDryRun}
+
+// riaasDurationBuckets are the upper bounds in seconds of the RIAAS request duration histogram.
+var riaasDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics holds the counters served in the Prometheus text format, next to the skip counts.
+var metrics = struct {
+	sync.Mutex
+	updateSuccesses map[string]int64
+	updateFailures  int64
+	riaasRetries    int64
+	riaasDuration   histogram
+}{updateSuccesses: map[string]int64{}, riaasDuration: histogram{buckets: riaasDurationBuckets, counts: make([]int64, len(riaasDurationBuckets))}}
+
+// histogram counts observations in buckets with cumulative upper bounds.
+type histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []int64
+	sum     float64
+	count   int64
+}
+
+// observe records an observation of d in seconds.
+func (h *histogram) observe(d time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	seconds := d.Seconds()
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// write writes the histogram called name in the Prometheus text format.
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative int64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// recordUpdateOutcome counts a label update of a node which was not skipped, by outcome if it succeeded.
+func recordUpdateOutcome(outcome string, err error) {
+	if err != nil {
+		atomic.AddInt64(&metrics.updateFailures, 1)
+		return
+	}
+	metrics.Lock()
+	metrics.updateSuccesses[outcome]++
+	metrics.Unlock()
+}
+
+// writeCounter writes the counter called name in the Prometheus text format.
+func writeCounter(w io.Writer, name, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// MetricsHandler serves the label update outcomes, RIAAS requests and skipped nodes in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.Lock()
+		fmt.Fprint(w, "# HELP vpc_node_label_update_success_total Node label updates which succeeded, by outcome.\n# TYPE vpc_node_label_update_success_total counter\n")
+		for _, outcome := range updateOutcomes {
+			fmt.Fprintf(w, "vpc_node_label_update_success_total{outcome=%q} %d\n", outcome, metrics.updateSuccesses[outcome])
+		}
+		metrics.Unlock()
+		writeCounter(w, "vpc_node_label_update_failure_total", "Node label updates which failed.", atomic.LoadInt64(&metrics.updateFailures))
+		writeCounter(w, "vpc_riaas_retry_total", "RIAAS requests retried after a failure.", atomic.LoadInt64(&metrics.riaasRetries))
+		metrics.riaasDuration.write(w, "vpc_riaas_request_duration_seconds", "Duration of the RIAAS requests.")
+
+		counts := SkipCounts()
+		reasons := make([]string, 0, len(counts))
+		for reason := range counts {
+			reasons = append(reasons, string(reason))
+		}
+		sort.Strings(reasons)
+		fmt.Fprint(w, "# HELP vpc_node_label_update_skipped_total Nodes left unlabeled, by reason.\n# TYPE vpc_node_label_update_skipped_total counter\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "vpc_node_label_update_skipped_total{reason=%q} %d\n", reason, counts[SkipReason(reason)])
+		}
+	})
+}
+
+// ServeMetrics serves the metrics on addr until ctx is done.
+func ServeMetrics(ctx context.Context, addr string, logger *zap.Logger) error {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, MetricsHandler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: metricsReadHeaderTimeout}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	logger.Info("Serving metrics", zap.String("addr", addr), zap.String("path", metricsPath))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scrapeMetrics returns the samples served at metricsURL by name and labels.
+func scrapeMetrics(t *testing.T, metricsURL string) map[string]float64 {
+	resp, err := http.Get(metricsURL) // #nosec G107
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[sep+1:], 64)
+		assert.Nil(t, err, line)
+		samples[line[:sep]] = value
+	}
+	return samples
+}
+
+func TestMetricsHandler(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	server := httptest.NewServer(MetricsHandler())
+	defer server.Close()
+	before := scrapeMetrics(t, server.URL)
+
+	// An update which applied the labels, then one which found them unchanged.
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+
+	// A dry run.
+	updater, _ = initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{DryRun: true}
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+
+	// A failed update, which retried its RIAAS request once.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	updater, _ = initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), failing.URL)
+	updater.Config = &Config{MaxAttempts: 2, RetryInterval: "1ms", RetryAttempts: map[string]int{errorClassServer: 2}}
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.NotNil(t, err)
+
+	RecordSkip(logger, SkipReasonNotVPC, "Not running on VPC infrastructure, nothing to label")
+
+	after := scrapeMetrics(t, server.URL)
+	delta := func(name string) float64 { return after[name] - before[name] }
+	assert.Equal(t, float64(1), delta(`vpc_node_label_update_success_total{outcome="applied"}`))
+	assert.Equal(t, float64(1), delta(`vpc_node_label_update_success_total{outcome="unchanged"}`))
+	assert.Equal(t, float64(1), delta(`vpc_node_label_update_success_total{outcome="dry_run"}`))
+	assert.Equal(t, float64(1), delta("vpc_node_label_update_failure_total"))
+	assert.Equal(t, float64(1), delta("vpc_riaas_retry_total"))
+	assert.Equal(t, float64(1), delta(`vpc_node_label_update_skipped_total{reason="not-vpc"}`))
+	// The failed update made two requests.
+	assert.GreaterOrEqual(t, delta("vpc_riaas_request_duration_seconds_count"), float64(3))
+	assert.Equal(t, after["vpc_riaas_request_duration_seconds_count"], after[`vpc_riaas_request_duration_seconds_bucket{le="+Inf"}`])
+	assert.LessOrEqual(t, after[`vpc_riaas_request_duration_seconds_bucket{le="0.05"}`], after[`vpc_riaas_request_duration_seconds_bucket{le="10"}`])
+}
+
+func TestHistogram(t *testing.T) {
+	h := &histogram{buckets: []float64{0.1, 1}, counts: make([]int64, 2)}
+	h.observe(50 * time.Millisecond)
+	h.observe(500 * time.Millisecond)
+	h.observe(2 * time.Second)
+	var out strings.Builder
+	h.write(&out, "test_seconds", "Test.")
+	assert.Equal(t, `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`, out.String())
+}
+
+func TestServeMetrics(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+
+	// The server stops once the context is done.
+	ctx, cancel := context.WithCancel(context.TODO())
+	served := make(chan error, 1)
+	go func() { served <- ServeMetrics(ctx, "127.0.0.1:0", logger) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("metrics server did not stop after the context was cancelled")
+	}
+
+	// An invalid address fails.
+	assert.NotNil(t, ServeMetrics(context.TODO(), "invalid-addr", logger))
+}
@@ -19,12 +19,22 @@ package nodeupdater
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
 // VpcNodeLabelUpdater ...
@@ -33,31 +43,539 @@ type VpcNodeLabelUpdater struct {
 	K8sClient           kubernetes.Interface
 	Logger              *zap.Logger
 	StorageSecretConfig *StorageSecretConfig
+	Webhook             *WebhookNotifier
+	Config              *Config
+	HTTPClient          *http.Client
+
+	// nodeFlights coalesces overlapping updates of the same node, keyed by node name.
+	nodeFlights sync.Map
+	// nodeMu guards c.Node. Updates of every node share c.Node, so an update holds it throughout.
+	nodeMu sync.Mutex
+	// riaasAttempts and lastRIAASStatus record the RIAAS requests made, for diagnostics.
+	riaasAttempts   int64
+	lastRIAASStatus int64
+}
+
+// nodeFlight tracks the update of one node, so that triggers arriving while it runs coalesce into
+// a single follow-up update.
+type nodeFlight struct {
+	mu      sync.Mutex
+	running bool
+	// followUp is the result of the follow-up update for the triggers which arrived while running, if any.
+	followUp *flightResult
+	// node is the latest node passed by a coalesced trigger, for the follow-up update.
+	node *v1.Node
+}
+
+// flightResult is the outcome of a follow-up update, set before ready is closed.
+type flightResult struct {
+	ready    chan struct{}
+	triggers int
+	done     bool
+	err      error
+}
+
+// start reports whether the caller should run the update. Otherwise it returns the result of the
+// follow-up update the caller is coalesced into, keeping node for the follow-up if set.
+func (f *nodeFlight) start(node *v1.Node) (*flightResult, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.running {
+		f.running = true
+		return nil, true
+	}
+	if f.followUp == nil {
+		f.followUp = &flightResult{ready: make(chan struct{})}
+	}
+	f.followUp.triggers++
+	if node != nil {
+		f.node = node
+	}
+	return f.followUp, false
+}
+
+// next returns the result of the follow-up update to run and the node to run it for, if triggers
+// arrived while running, ending the flight otherwise.
+func (f *nodeFlight) next() (*flightResult, *v1.Node) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	followUp, node := f.followUp, f.node
+	f.followUp, f.node = nil, nil
+	if followUp == nil {
+		f.running = false
+	}
+	return followUp, node
 }
 
 // UpdateNodeLabel gets the details of the newly added node from riaas and updates the labels.
 // Returns false and err as nil if labels not updated. else returns true
+// A call made while the node is being updated waits for a single follow-up update, run once the
+// running one is done however many such calls arrive, and returns its result.
 func (c *VpcNodeLabelUpdater) UpdateNodeLabel(ctx context.Context, workerNodeName string) (done bool, err error) {
-	nodeinfo, err := c.GetWorkerDetails(workerNodeName)
-	if err != nil {
+	return c.updateNodeLabels(ctx, workerNodeName, nil)
+}
+
+// UpdateNode updates the labels of node like UpdateNodeLabel, replacing c.Node with it for the update.
+func (c *VpcNodeLabelUpdater) UpdateNode(ctx context.Context, node *v1.Node) (done bool, err error) {
+	return c.updateNodeLabels(ctx, node.Name, node)
+}
+
+// updateNodeLabels coalesces the updates of the node called workerNodeName, see UpdateNodeLabel.
+func (c *VpcNodeLabelUpdater) updateNodeLabels(ctx context.Context, workerNodeName string, node *v1.Node) (done bool, err error) {
+	flight, _ := c.nodeFlights.LoadOrStore(workerNodeName, &nodeFlight{})
+	result, run := flight.(*nodeFlight).start(node)
+	if !run {
+		c.Logger.Info("Node label update already running, waiting for a follow-up update", zap.String("workerNodeName", workerNodeName))
+		select {
+		case <-result.ready:
+			return result.done, result.err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	done, err = c.updateNodeLabel(ctx, workerNodeName, node)
+	for {
+		followUp, next := flight.(*nodeFlight).next()
+		if followUp == nil {
+			return done, err
+		}
+		c.Logger.Info("Node label update was triggered while running, updating again", zap.String("workerNodeName", workerNodeName), zap.Int("triggers", followUp.triggers))
+		done, err = c.updateNodeLabel(ctx, workerNodeName, next)
+		followUp.done, followUp.err = done, err
+		close(followUp.ready)
+	}
+}
+
+// updateNodeLabel runs a single update of the node labels, for node if set and c.Node otherwise.
+func (c *VpcNodeLabelUpdater) updateNodeLabel(ctx context.Context, workerNodeName string, node *v1.Node) (done bool, err error) {
+	// c.Node is only read and replaced under the lock.
+	unlock := c.lockNode()
+	defer unlock()
+	if node != nil {
+		c.Node = node
+	}
+	if c.Node != nil && c.Node.ObjectMeta.DeletionTimestamp != nil {
+		RecordSkip(c.Logger, SkipReasonDeleting, "Node is being deleted, skipping label update", zap.String("workerNodeName", workerNodeName), zap.Time("deletionTimestamp", c.Node.ObjectMeta.DeletionTimestamp.Time))
+		return true, nil
+	}
+	if !c.getConfig().IsOptedIn(c.Node) {
+		RecordSkip(c.Logger, SkipReasonNotOptedIn, "Node has not opted in to labeling, skipping label update", zap.String("workerNodeName", workerNodeName), zap.String("annotation", optInAnnotationKey))
+		return true, nil
+	}
+	var nodeinfo *NodeInfo
+	var changed map[string]string
+	var outcome string
+	defer func() {
+		recordUpdateOutcome(outcome, err)
+		c.audit(workerNodeName, nodeinfo, changed, err)
+		c.notifyWebhook(ctx, workerNodeName, nodeinfo, err)
+	}()
+
+	var labels map[string]string
+	if nodeinfo, labels, err = c.computeNodeLabels(ctx, workerNodeName); err != nil {
 		return false, err
 	}
+	changed = changedLabels(c.Node.ObjectMeta.Labels, labels)
+	if c.getConfig().DryRun {
+		c.Logger.Info("Dry run, not updating the node", zap.String("workerNodeName", workerNodeName), zap.Reflect("nodeInfo", nodeinfo), zap.Any("labels", labels), zap.Any("changedLabels", changed))
+		outcome = updateOutcomeDryRun
+		return true, nil
+	}
+	if len(changed) == 0 && !c.getConfig().bootIDChanged(c.Node) {
+		RecordSkip(c.Logger, SkipReasonAlreadyLabeled, "Node labels already match the computed values, skipping update", zap.Reflect("workerNodeName", workerNodeName))
+		if err = c.refreshNodeAnnotations(ctx, workerNodeName, labels); err != nil {
+			return false, err
+		}
+		outcome = updateOutcomeUnchanged
+	} else {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			return c.writeNodeMetadata(ctx, workerNodeName, labels)
+		})
+		if err != nil {
+			return false, err
+		}
+		c.Logger.Info("Added required labels for the node, ", zap.Reflect("workerNodeName", workerNodeName))
+		outcome = updateOutcomeApplied
+	}
+	if err = c.setLabelsAppliedCondition(ctx, workerNodeName); err != nil {
+		return false, err
+	}
+	c.annotatePod(ctx, nodeinfo)
+	return true, nil
+}
+
+// annotatePod records the discovered zone and region on the updater's own pod, if enabled.
+// It is best-effort: failures are logged and do not fail the update.
+func (c *VpcNodeLabelUpdater) annotatePod(ctx context.Context, nodeinfo *NodeInfo) {
+	cfg := c.getConfig()
+	if !cfg.AnnotatePod || nodeinfo.Zone == "" {
+		return
+	}
+	if cfg.PodName == "" || cfg.PodNamespace == "" {
+		c.Logger.Warn("Skipping pod annotations, POD_NAME and POD_NAMESPACE must be provided")
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				zoneAnnotationKey:   nodeinfo.Zone,
+				regionAnnotationKey: nodeinfo.Region,
+			},
+		},
+	})
+	if err != nil {
+		c.Logger.Warn("Failed to build pod annotations patch", zap.Error(err))
+		return
+	}
+	if _, err = c.K8sClient.CoreV1().Pods(cfg.PodNamespace).Patch(ctx, cfg.PodName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		c.Logger.Warn("Failed to annotate pod", zap.String("podName", cfg.PodName), zap.String("podNamespace", cfg.PodNamespace), zap.Error(err))
+		return
+	}
+	c.Logger.Info("Annotated pod with zone and region", zap.String("podName", cfg.PodName), zap.String("podNamespace", cfg.PodNamespace))
+}
+
+// computeNodeLabels looks up the instance of c.Node and returns the labels an update sets on it, after the
+// configured policies. The node details are returned once found, even if the labels cannot be computed.
+// The caller holds the node lock.
+func (c *VpcNodeLabelUpdater) computeNodeLabels(ctx context.Context, workerNodeName string) (*NodeInfo, map[string]string, error) {
+	lookupName := c.getConfig().TransformNodeName(workerNodeName)
+	if lookupName != workerNodeName {
+		c.Logger.Info("Transformed node name for instance lookup", zap.String("workerNodeName", workerNodeName), zap.String("lookupName", lookupName))
+	}
+	nodeinfo, err := c.GetWorkerDetails(ctx, lookupName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if provisioningStatuses[nodeinfo.Status] {
+		next, err := c.applyProvisioningPolicy(ctx, lookupName, nodeinfo)
+		if err != nil {
+			return nodeinfo, nil, err
+		}
+		nodeinfo = next
+	}
+
+	if err = c.checkProviderID(nodeinfo); err != nil {
+		return nodeinfo, nil, err
+	}
+	if nodeinfo.Zone == "" {
+		if err = c.applyMissingZonePolicy(nodeinfo); err != nil {
+			return nodeinfo, nil, err
+		}
+	}
+	labels := c.getNodeLabels(nodeinfo)
+	if err = c.applyTopologyConflictPolicy(labels); err != nil {
+		return nodeinfo, nil, err
+	}
+	if maxLabels := c.getConfig().GetMaxLabels(); len(labels) > maxLabels {
+		return nodeinfo, nil, fmt.Errorf("refusing to set %d labels on node %s, more than the maximum of %d", len(labels), workerNodeName, maxLabels)
+	}
+	if err = c.enforceLabelValueLength(labels); err != nil {
+		return nodeinfo, nil, err
+	}
+	return nodeinfo, labels, nil
+}
+
+// writeNodeMetadata updates the node with the given labels and the enabled annotations.
+// On a conflict the latest node is fetched so the next attempt applies on top of it.
+func (c *VpcNodeLabelUpdater) writeNodeMetadata(ctx context.Context, workerNodeName string, labels map[string]string) error {
+	node := c.Node.DeepCopy()
+	if node.ObjectMeta.Labels == nil {
+		node.ObjectMeta.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		node.ObjectMeta.Labels[key] = value
+	}
+	recordAppliedLabelKeys(node, labels)
+	if c.getConfig().AnnotateLastReconcile {
+		if node.ObjectMeta.Annotations == nil {
+			node.ObjectMeta.Annotations = make(map[string]string)
+		}
+		node.ObjectMeta.Annotations[lastReconcileAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if c.getConfig().RelabelOnBootIDChange && node.Status.NodeInfo.BootID != "" {
+		if node.ObjectMeta.Annotations == nil {
+			node.ObjectMeta.Annotations = make(map[string]string)
+		}
+		node.ObjectMeta.Annotations[bootIDAnnotationKey] = node.Status.NodeInfo.BootID
+	}
+
+	updatedNode, err := c.K8sClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		c.Logger.Warn("Conflict updating node, retrying with the latest version", zap.String("workerNodeName", workerNodeName))
+		latestNode, getErr := c.K8sClient.CoreV1().Nodes().Get(ctx, workerNodeName, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		c.Node = latestNode
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	c.Node = updatedNode
+	return nil
+}
+
+// refreshNodeAnnotations brings the annotations written with the labels up to date on a node whose labels
+// need no change, with a metadata-only patch. Nothing is written if they are already up to date.
+func (c *VpcNodeLabelUpdater) refreshNodeAnnotations(ctx context.Context, workerNodeName string, labels map[string]string) error {
+	node := c.Node.DeepCopy()
+	recordAppliedLabelKeys(node, labels)
+	annotations := map[string]string{}
+	if node.ObjectMeta.Annotations[managedKeysAnnotationKey] != c.Node.ObjectMeta.Annotations[managedKeysAnnotationKey] {
+		annotations[managedKeysAnnotationKey] = node.ObjectMeta.Annotations[managedKeysAnnotationKey]
+	}
+	if c.getConfig().AnnotateLastReconcile {
+		annotations[lastReconcileAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	updatedNode, err := c.K8sClient.CoreV1().Nodes().Patch(ctx, workerNodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		c.Logger.Error("Failed to refresh node annotations", zap.String("workerNodeName", workerNodeName), zap.Error(err))
+		return err
+	}
+	c.Node = updatedNode
+	return nil
+}
 
+// setLabelsAppliedCondition sets the labels applied condition on the node status, if enabled.
+func (c *VpcNodeLabelUpdater) setLabelsAppliedCondition(ctx context.Context, workerNodeName string) error {
+	if !c.getConfig().SetNodeCondition {
+		return nil
+	}
+	now := metav1.NewTime(time.Now())
+	condition := v1.NodeCondition{
+		Type:               labelsAppliedConditionType,
+		Status:             v1.ConditionTrue,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             labelsAppliedConditionReason,
+		Message:            "VPC node labels are applied",
+	}
+	for _, existing := range c.Node.Status.Conditions {
+		if existing.Type == condition.Type && existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	updatedNode, err := c.K8sClient.CoreV1().Nodes().Patch(ctx, workerNodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		c.Logger.Error("Failed to set node condition", zap.String("workerNodeName", workerNodeName), zap.String("condition", string(condition.Type)), zap.Error(err))
+		return err
+	}
+	c.Node = updatedNode
+	c.Logger.Info("Set node condition", zap.String("workerNodeName", workerNodeName), zap.String("condition", string(condition.Type)))
+	return nil
+}
+
+// getConfig returns the updater configuration, or the defaults when none is set.
+func (c *VpcNodeLabelUpdater) getConfig() *Config {
+	if c.Config == nil {
+		return &Config{}
+	}
+	return c.Config
+}
+
+// enforceLabelValueLength applies the configured overflow policy to label values longer than allowed.
+func (c *VpcNodeLabelUpdater) enforceLabelValueLength(labels map[string]string) error {
+	for key, value := range labels {
+		if len(value) <= validation.LabelValueMaxLength {
+			continue
+		}
+		if c.getConfig().LabelValueOverflow == LabelValueOverflowError {
+			c.Logger.Error("Label value exceeds maximum length", zap.String("key", key), zap.String("value", value))
+			return fmt.Errorf("value of label %s exceeds %d characters", key, validation.LabelValueMaxLength)
+		}
+		// Label values must end with an alphanumeric character.
+		truncated := strings.TrimRight(value[:validation.LabelValueMaxLength], "-_.")
+		c.Logger.Warn("Truncating label value exceeding maximum length", zap.String("key", key), zap.String("value", value), zap.String("truncatedValue", truncated))
+		labels[key] = truncated
+	}
+	return nil
+}
+
+// lockNode acquires the lock guarding c.Node and returns the function releasing it.
+func (c *VpcNodeLabelUpdater) lockNode() func() {
+	c.nodeMu.Lock()
+	return c.nodeMu.Unlock
+}
+
+// getNodeLabels returns the labels to be set on the node for the given node details.
+func (c *VpcNodeLabelUpdater) getNodeLabels(nodeinfo *NodeInfo) map[string]string {
 	// Are adding both worker-id and instance-id label to satisfy all environements.
 	// TODO: remove worker-id label after its dependence is removed.
-	c.Node.ObjectMeta.Labels[workerIDLabelKey] = nodeinfo.InstanceID
-	c.Node.ObjectMeta.Labels[instanceIDLabelKey] = nodeinfo.InstanceID
-	c.Node.ObjectMeta.Labels[failureRegionLabelKey] = nodeinfo.Region
-	c.Node.ObjectMeta.Labels[failureZoneLabelKey] = nodeinfo.Zone
-	c.Node.ObjectMeta.Labels[topologyRegionLabelKey] = nodeinfo.Region
-	c.Node.ObjectMeta.Labels[topologyZoneLabelKey] = nodeinfo.Zone
-	c.Node.ObjectMeta.Labels[vpcBlockLabelKey] = "true"
-
-	_, err = c.K8sClient.CoreV1().Nodes().Update(ctx, c.Node, metav1.UpdateOptions{})
-	if err == nil && !errors.IsConflict(err) {
-		c.Logger.Info("Added required labels for the node, ", zap.Reflect("workerNodeName", workerNodeName))
-		return true, nil
+	cfg := c.getConfig()
+	labels := map[string]string{
+		cfg.labelKey(workerIDLabelKey):   nodeinfo.InstanceID,
+		cfg.labelKey(instanceIDLabelKey): nodeinfo.InstanceID,
+		cfg.labelKey(vpcBlockLabelKey):   "true",
 	}
+	// Topology labels are left out when the zone is unknown.
+	if nodeinfo.Zone != "" {
+		region, zone := nodeinfo.Region, nodeinfo.Zone
+		if cfg.LowercaseTopology {
+			region, zone = strings.ToLower(region), strings.ToLower(zone)
+		}
+		labels[cfg.labelKey(failureRegionLabelKey)] = region
+		labels[cfg.labelKey(failureZoneLabelKey)] = zone
+		labels[cfg.labelKey(topologyRegionLabelKey)] = region
+		labels[cfg.labelKey(topologyZoneLabelKey)] = zone
+	}
+	// The VPC ID label is left out for instances RIAAS reports without a VPC.
+	if nodeinfo.VpcID != "" {
+		labels[cfg.labelKey(vpcIDLabelKey)] = nodeinfo.VpcID
+	}
+	// The profile label is left out for instances RIAAS reports without a profile.
+	if nodeinfo.Profile != "" {
+		labels[cfg.labelKey(instanceProfileLabelKey)] = nodeinfo.Profile
+	}
+	if cfg.InstanceProfileLabels && nodeinfo.Bandwidth > 0 {
+		labels[cfg.labelKey(instanceBandwidthLabelKey)] = strconv.FormatInt(nodeinfo.Bandwidth, 10)
+	}
+	return labels
+}
+
+// checkProviderID cross-checks the discovered instance ID against the one encoded in the node's provider
+// ID, if any, warning on mismatch or failing when the fail policy is set.
+func (c *VpcNodeLabelUpdater) checkProviderID(nodeinfo *NodeInfo) error {
+	if c.Node == nil {
+		return nil
+	}
+	providerInstanceID, ok := parseProviderID(c.Node.Spec.ProviderID)
+	if !ok || providerInstanceID == nodeinfo.InstanceID {
+		return nil
+	}
+	if c.getConfig().ProviderIDMismatchPolicy == ProviderIDMismatchFail {
+		return fmt.Errorf("instance %s does not match instance %s in the node provider ID %s", nodeinfo.InstanceID, providerInstanceID, c.Node.Spec.ProviderID)
+	}
+	c.Logger.Warn("Instance does not match the node provider ID", zap.String("instanceID", nodeinfo.InstanceID), zap.String("providerID", c.Node.Spec.ProviderID))
+	return nil
+}
+
+// applyTopologyConflictPolicy handles topology labels already set on the node with a value other than
+// the computed one according to the configured policy, dropping them from labels when they are kept.
+func (c *VpcNodeLabelUpdater) applyTopologyConflictPolicy(labels map[string]string) error {
+	cfg := c.getConfig()
+	policy := cfg.TopologyConflictPolicy
+	if policy == "" || policy == TopologyConflictOverwrite {
+		return nil
+	}
+	for _, defaultKey := range []string{failureRegionLabelKey, failureZoneLabelKey, topologyRegionLabelKey, topologyZoneLabelKey} {
+		key := cfg.labelKey(defaultKey)
+		value, ok := labels[key]
+		if !ok {
+			continue
+		}
+		existing, present := c.Node.ObjectMeta.Labels[key]
+		if !present || existing == value {
+			continue
+		}
+		if policy == TopologyConflictErrorOnMismatch {
+			return fmt.Errorf("node label %s is %q, refusing to change it to %q", key, existing, value)
+		}
+		c.Logger.Warn("Keeping existing topology label", zap.String("key", key), zap.String("existing", existing), zap.String("computed", value))
+		delete(labels, key)
+	}
+	return nil
+}
+
+// applyProvisioningPolicy handles node details of a provisioning instance according to the configured
+// policy. With the wait policy it polls the instance until it is running, giving up after the
+// provisioning wait timeout or when ctx is done.
+func (c *VpcNodeLabelUpdater) applyProvisioningPolicy(ctx context.Context, lookupName string, nodeinfo *NodeInfo) (*NodeInfo, error) {
+	cfg := c.getConfig()
+	if cfg.ProvisioningPolicy == ProvisioningProceed {
+		c.Logger.Warn("Instance is still provisioning, labeling with the details available", zap.String("instanceID", nodeinfo.InstanceID), zap.String("status", nodeinfo.Status))
+		return nodeinfo, nil
+	}
+	timeout := cfg.GetProvisioningWaitTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(provisioningPollInterval)
+	defer ticker.Stop()
+	for provisioningStatuses[nodeinfo.Status] {
+		c.Logger.Info("Instance is still provisioning, waiting for it to be running", zap.String("instanceID", nodeinfo.InstanceID), zap.String("status", nodeinfo.Status))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("instance %s is still %s after waiting %s for it to be running", nodeinfo.InstanceID, nodeinfo.Status, timeout)
+		case <-ticker.C:
+		}
+		next, err := c.GetWorkerDetails(ctx, lookupName)
+		if err != nil {
+			return nil, err
+		}
+		nodeinfo = next
+	}
+	return nodeinfo, nil
+}
+
+// applyMissingZonePolicy handles node details without a zone according to the configured policy.
+func (c *VpcNodeLabelUpdater) applyMissingZonePolicy(nodeinfo *NodeInfo) error {
+	cfg := c.getConfig()
+	switch cfg.MissingZonePolicy {
+	case MissingZoneSkipTopology:
+		c.Logger.Warn("Instance has no zone, skipping topology labels", zap.String("instanceID", nodeinfo.InstanceID))
+		return nil
+	case MissingZoneUseOverride:
+		if cfg.ZoneOverride == "" {
+			return fmt.Errorf("instance %s has no zone and no zone override is configured", nodeinfo.InstanceID)
+		}
+		c.Logger.Warn("Instance has no zone, using zone override", zap.String("instanceID", nodeinfo.InstanceID), zap.String("zone", cfg.ZoneOverride))
+		nodeinfo.Zone = cfg.ZoneOverride
+		nodeinfo.Region = cfg.GetRegionFromZone(cfg.ZoneOverride)
+		return nil
+	default:
+		return fmt.Errorf("instance %s has no zone, topology labels cannot be computed", nodeinfo.InstanceID)
+	}
+}
 
-	return false, err
+// changedLabels returns the desired labels which are missing or differ from the current labels.
+func changedLabels(current, desired map[string]string) map[string]string {
+	changed := map[string]string{}
+	for key, value := range desired {
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			changed[key] = value
+		}
+	}
+	return changed
+}
+
+// notifyWebhook reports the outcome of a labeling attempt to the configured webhook, if any.
+func (c *VpcNodeLabelUpdater) notifyWebhook(ctx context.Context, workerNodeName string, nodeinfo *NodeInfo, err error) {
+	if c.Webhook == nil {
+		return
+	}
+	event := &LabelEvent{
+		NodeName: workerNodeName,
+		Result:   webhookResultSuccess,
+	}
+	if c.getConfig().DryRun {
+		event.Result = webhookResultDryRun
+	}
+	if nodeinfo != nil {
+		event.InstanceID = nodeinfo.InstanceID
+		event.Labels = c.getNodeLabels(nodeinfo)
+	}
+	if err != nil {
+		event.Result = webhookResultFailure
+		event.Error = err.Error()
+	}
+	c.Webhook.Notify(ctx, event)
 }
@@ -19,14 +19,89 @@ package nodeupdater
 
 import (
 	"context"
+	"encoding/json"
 	errors "errors"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
+// newTestRIAASServer returns a server which serves the given instances as an instance list,
+// honouring the name filter when present, and single instances under /instances/{id}.
+func newTestRIAASServer(t *testing.T, instances []*Instance) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if id := path.Base(r.URL.Path); strings.HasSuffix(path.Dir(r.URL.Path), "instances") {
+			for _, instance := range instances {
+				if instance.ID == id {
+					_ = json.NewEncoder(w).Encode(instance)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"not_found"}]}`))
+			return
+		}
+		filtered := []*Instance{}
+		for _, instance := range instances {
+			if name := r.URL.Query().Get("name"); name == "" || name == instance.Name {
+				filtered = append(filtered, instance)
+			}
+		}
+		if err := json.NewEncoder(w).Encode(&InstanceList{Instances: filtered}); err != nil {
+			t.Errorf("failed to encode instance list: %v", err)
+		}
+	}))
+}
+
+// initFakeNodeLabelUpdater returns an updater backed by a fake clientset holding node, pointed at riaasURL.
+func initFakeNodeLabelUpdater(t *testing.T, node *v1.Node, riaasURL string) (*VpcNodeLabelUpdater, *fake.Clientset) {
+	updater := initNodeLabelUpdater(t)
+	clientset := fake.NewSimpleClientset(node)
+	riaasInsURL, err := url.Parse(riaasURL)
+	assert.Nil(t, err)
+	updater.Node = node
+	updater.K8sClient = clientset
+	updater.StorageSecretConfig = &StorageSecretConfig{RiaasEndpointURL: riaasInsURL, IAMAccessToken: "valid-token"}
+	return updater, clientset
+}
+
+// newTestNode returns a node with the given name and labels.
+func newTestNode(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+// newTestInstance returns a running instance with the given name, id, zone and primary ip.
+func newTestInstance(name, id, zone, ip string) *Instance {
+	return &Instance{
+		Name:                    name,
+		ID:                      id,
+		Status:                  "running",
+		Zone:                    &Zone{Name: zone},
+		PrimaryNetworkInterface: &NetworkInterface{PrimaryIpv4Address: ip},
+	}
+}
+
 func TestUpdateNodeLabel(t *testing.T) {
 	testCases := []struct {
 		name             string
@@ -87,3 +162,998 @@ func TestUpdateNodeLabel(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateNodeLabelSkipsUnchangedLabels(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	nodeinfo := &NodeInfo{InstanceID: "valid-instance-id", Region: "us-south", Zone: "us-south-1"}
+
+	// Node missing a label is updated.
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 1, countActions(clientset, "update"))
+
+	// Node already carrying all computed labels is not updated.
+	updater, clientset = initFakeNodeLabelUpdater(t, newTestNode("valid-worker", (&VpcNodeLabelUpdater{}).getNodeLabels(nodeinfo)), riaas.URL)
+	done, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 0, countActions(clientset, "update"))
+	// Only the annotations written with the labels are refreshed, with a metadata patch.
+	assert.Equal(t, 1, countActions(clientset, "patch"))
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 7, len(getAppliedLabelKeys(node)))
+
+	// Once they are up to date nothing is written, unless the last reconcile is annotated.
+	updater, clientset = initFakeNodeLabelUpdater(t, node, riaas.URL)
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, countActions(clientset, "update")+countActions(clientset, "patch"))
+	updater, clientset = initFakeNodeLabelUpdater(t, node, riaas.URL)
+	updater.Config = &Config{AnnotateLastReconcile: true}
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, countActions(clientset, "update"))
+	assert.Equal(t, 1, countActions(clientset, "patch"))
+	node, err = clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, node.Annotations[lastReconcileAnnotationKey])
+
+	// Node with a stale value is updated.
+	labels := (&VpcNodeLabelUpdater{}).getNodeLabels(nodeinfo)
+	labels[topologyZoneLabelKey] = "us-south-2"
+	updater, clientset = initFakeNodeLabelUpdater(t, newTestNode("valid-worker", labels), riaas.URL)
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, countActions(clientset, "update"))
+}
+
+// countActions returns the number of recorded actions with the given verb.
+func countActions(clientset *fake.Clientset, verb string) int {
+	count := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == verb {
+			count++
+		}
+	}
+	return count
+}
+
+func TestUpdateNodeLabelConcurrent(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", nil), riaas.URL)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	// Overlapping updates coalesce into at most one follow-up, which sees matching labels.
+	assert.Equal(t, 1, countActions(clientset, "update"))
+	lookups := atomic.LoadInt64(&updater.riaasAttempts)
+	assert.True(t, lookups >= 1 && lookups <= 2, "lookups: %d", lookups)
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid-instance-id", node.Labels[instanceIDLabelKey])
+}
+
+func TestUpdateNodeConcurrentNodes(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("worker-a", "instance-a", "us-south-1", "10.0.0.1"),
+		newTestInstance("worker-b", "instance-b", "us-south-2", "10.0.0.2"),
+	})
+	defer riaas.Close()
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("worker-a", nil), riaas.URL)
+	_, err := clientset.CoreV1().Nodes().Create(context.TODO(), newTestNode("worker-b", nil), metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	// Updates of different nodes share c.Node, so each must label its own node.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for _, name := range []string{"worker-a", "worker-b"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				_, err := updater.UpdateNode(context.TODO(), newTestNode(name, nil))
+				assert.Nil(t, err)
+			}(name)
+		}
+	}
+	wg.Wait()
+
+	for name, expInstanceID := range map[string]string{"worker-a": "instance-a", "worker-b": "instance-b"} {
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, expInstanceID, node.Labels[instanceIDLabelKey], name)
+	}
+}
+
+func TestUpdateNodeLabelOverlappingTriggers(t *testing.T) {
+	instances := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer instances.Close()
+	// The server holds the first lookup until released and records the largest number of lookups in flight at once.
+	release := make(chan struct{})
+	var requests, inFlight, maxInFlight int32
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if current <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, current) {
+				break
+			}
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release
+		}
+		instances.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer riaas.Close()
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", nil), riaas.URL)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 1 }, 5*time.Second, time.Millisecond)
+
+	// Triggers arriving while the node is updated wait for the follow-up update.
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+			assert.Nil(t, err)
+			assert.True(t, done)
+		}()
+	}
+	assert.Eventually(t, func() bool { return coalescedTriggers(updater, "valid-worker") == 4 }, 5*time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Overlapping triggers for one node never run their RIAAS and update cycles concurrently, and
+	// coalesce into exactly one follow-up.
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxInFlight))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&updater.riaasAttempts))
+	assert.Equal(t, 1, countActions(clientset, "update"))
+}
+
+// coalescedTriggers returns the number of triggers waiting for the follow-up update of the node.
+func coalescedTriggers(updater *VpcNodeLabelUpdater, workerNodeName string) int {
+	flight, ok := updater.nodeFlights.Load(workerNodeName)
+	if !ok {
+		return 0
+	}
+	f := flight.(*nodeFlight)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.followUp == nil {
+		return 0
+	}
+	return f.followUp.triggers
+}
+
+func TestUpdateNodeLabelCoalescedFailure(t *testing.T) {
+	// The server holds the first lookup until released and fails every lookup.
+	release := make(chan struct{})
+	var requests int32
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer riaas.Close()
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", nil), riaas.URL)
+	updater.Config = &Config{MaxAttempts: 1}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 1 }, 5*time.Second, time.Millisecond)
+	go func() {
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool { return coalescedTriggers(updater, "valid-worker") == 1 }, 5*time.Second, time.Millisecond)
+
+	// The coalesced trigger does not report success before the follow-up update fails.
+	select {
+	case err := <-errs:
+		t.Fatalf("update returned before the running update finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		var statusErr *ErrRIAASStatus
+		assert.True(t, errors.As(<-errs, &statusErr))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, 0, countActions(clientset, "update"))
+}
+
+func TestUpdateNodeLabelCoalescedCancel(t *testing.T) {
+	release := make(chan struct{})
+	var requests int32
+	instances := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer instances.Close()
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release
+		}
+		instances.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer riaas.Close()
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", nil), riaas.URL)
+
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 1 }, 5*time.Second, time.Millisecond)
+
+	// A waiting trigger gives up once its context is done.
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	done, err := updater.UpdateNodeLabel(ctx, "valid-worker")
+	assert.False(t, done)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	close(release)
+	<-running
+}
+
+func TestEnforceLabelValueLength(t *testing.T) {
+	longValue := strings.Repeat("a", 62) + "-bcd"
+	testCases := []struct {
+		name      string
+		policy    string
+		labels    map[string]string
+		expLabels map[string]string
+		expErr    bool
+	}{
+		{
+			name:      "values within limit",
+			policy:    LabelValueOverflowError,
+			labels:    map[string]string{topologyZoneLabelKey: "us-south-1"},
+			expLabels: map[string]string{topologyZoneLabelKey: "us-south-1"},
+		},
+		{
+			name:      "truncate mode",
+			policy:    LabelValueOverflowTruncate,
+			labels:    map[string]string{topologyZoneLabelKey: "us-south-1", instanceIDLabelKey: longValue},
+			expLabels: map[string]string{topologyZoneLabelKey: "us-south-1", instanceIDLabelKey: strings.Repeat("a", 62)},
+		},
+		{
+			name:      "default mode truncates",
+			labels:    map[string]string{instanceIDLabelKey: longValue},
+			expLabels: map[string]string{instanceIDLabelKey: strings.Repeat("a", 62)},
+		},
+		{
+			name:      "error mode",
+			policy:    LabelValueOverflowError,
+			labels:    map[string]string{instanceIDLabelKey: longValue},
+			expLabels: map[string]string{instanceIDLabelKey: longValue},
+			expErr:    true,
+		},
+	}
+	updater := initNodeLabelUpdater(t)
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater.Config = &Config{LabelValueOverflow: tc.policy}
+		err := updater.enforceLabelValueLength(tc.labels)
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, tc.expLabels, tc.labels)
+	}
+}
+
+func TestUpdateNodeLabelAnnotatesLastReconcile(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	for _, enabled := range []bool{true, false} {
+		t.Logf("Test case: annotate last reconcile %v", enabled)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+		updater.Config = &Config{AnnotateLastReconcile: enabled}
+		before := time.Now().UTC().Truncate(time.Second)
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		assert.Nil(t, err)
+		value, ok := node.Annotations[lastReconcileAnnotationKey]
+		assert.Equal(t, enabled, ok)
+		if enabled {
+			reconciled, err := time.Parse(time.RFC3339, value)
+			assert.Nil(t, err)
+			assert.False(t, reconciled.Before(before))
+		}
+	}
+
+	// A failed reconcile leaves the annotation untouched.
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("unknown-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{AnnotateLastReconcile: true}
+	_, err := updater.UpdateNodeLabel(context.TODO(), "unknown-worker")
+	assert.NotNil(t, err)
+	node, _ := clientset.CoreV1().Nodes().Get(context.TODO(), "unknown-worker", metav1.GetOptions{})
+	assert.Empty(t, node.Annotations[lastReconcileAnnotationKey])
+}
+
+func TestUpdateNodeLabelBootIDChange(t *testing.T) {
+	instance := newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")
+	riaas := newTestRIAASServer(t, []*Instance{instance})
+	defer riaas.Close()
+
+	// The node carries all labels from before the reboot, recorded with the previous boot ID.
+	node := newTestNode("valid-worker", map[string]string{})
+	updater, clientset := initFakeNodeLabelUpdater(t, node, riaas.URL)
+	updater.Config = &Config{}
+	_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	node, err = clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	node.Annotations = map[string]string{bootIDAnnotationKey: "boot-id-1"}
+	node.Status.NodeInfo.BootID = "boot-id-2"
+	_, err = clientset.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+
+	cfg := &Config{}
+	assert.False(t, cfg.NeedsRelabel(node))
+	cfg.RelabelOnBootIDChange = true
+	assert.True(t, cfg.NeedsRelabel(node))
+
+	updater, clientset = initFakeNodeLabelUpdater(t, node, riaas.URL)
+	updater.Config = cfg
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 1, countActions(clientset, "update"))
+
+	updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "boot-id-2", updated.Annotations[bootIDAnnotationKey])
+	assert.False(t, cfg.NeedsRelabel(updated))
+
+	// Once the boot ID is recorded, an unchanged node is not written again.
+	updater, clientset = initFakeNodeLabelUpdater(t, updated, riaas.URL)
+	updater.Config = cfg
+	_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, countActions(clientset, "update"))
+}
+
+func TestUpdateNodeLabelDryRun(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	receiver := &webhookReceiver{}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	logger, buf := newBufferLogger()
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Logger = logger
+	updater.Config = &Config{DryRun: true, AnnotateLastReconcile: true, SetNodeCondition: true}
+	updater.Webhook = NewWebhookNotifier(hook.URL, time.Second, logger)
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+
+	// Neither the audit record nor the webhook event claim the labels were written.
+	updater.Webhook.Wait()
+	records := getAuditRecords(t, buf)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, auditOutcomeDryRun, records[0]["outcome"])
+	assert.NotContains(t, records[0], "labelsChanged")
+	assert.Contains(t, records[0], "labelsToChange")
+	assert.Equal(t, 1, len(receiver.events))
+	assert.Equal(t, webhookResultDryRun, receiver.events[0].Result)
+
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Empty(t, node.Labels)
+	assert.Contains(t, buf.String(), "Dry run, not updating the node")
+	assert.Contains(t, buf.String(), `"`+instanceIDLabelKey+`":"valid-instance-id"`)
+}
+
+func TestUpdateNodeLabelOptIn(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name        string
+		optIn       bool
+		annotations map[string]string
+		expLabeled  bool
+	}{
+		{name: "opt-in disabled", expLabeled: true},
+		{name: "opted in", optIn: true, annotations: map[string]string{optInAnnotationKey: "true"}, expLabeled: true},
+		{name: "not annotated", optIn: true},
+		{name: "opted out", optIn: true, annotations: map[string]string{optInAnnotationKey: "false"}},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		node := newTestNode("valid-worker", map[string]string{})
+		node.Annotations = tc.annotations
+		updater, clientset := initFakeNodeLabelUpdater(t, node, riaas.URL)
+		updater.Config = &Config{OptIn: tc.optIn}
+		assert.Equal(t, tc.expLabeled, updater.Config.IsOptedIn(node))
+		done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+		assert.True(t, done)
+
+		updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expLabeled, updated.Labels[instanceIDLabelKey] == "valid-instance-id")
+		if !tc.expLabeled {
+			assert.Equal(t, 0, countActions(clientset, "update"))
+		}
+	}
+}
+
+func TestUpdateNodeLabelInstanceProfileLabels(t *testing.T) {
+	bandwidth := int64(4000)
+	withProfile := newTestInstance("profiled-worker", "instance-id-1", "us-south-1", "10.0.0.1")
+	withProfile.Profile = &Profile{Name: "bx2-4x16"}
+	withProfile.Bandwidth = &bandwidth
+	riaas := newTestRIAASServer(t, []*Instance{
+		withProfile,
+		newTestInstance("plain-worker", "instance-id-2", "us-south-1", "10.0.0.2"),
+	})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name         string
+		workerName   string
+		enabled      bool
+		expProfile   string
+		expBandwidth string
+	}{
+		{name: "profile and bandwidth present", workerName: "profiled-worker", enabled: true, expProfile: "bx2-4x16", expBandwidth: "4000"},
+		{name: "profile and bandwidth absent", workerName: "plain-worker", enabled: true},
+		{name: "bandwidth disabled", workerName: "profiled-worker", expProfile: "bx2-4x16"},
+		{name: "nil profile", workerName: "plain-worker"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode(tc.workerName, map[string]string{}), riaas.URL)
+		updater.Config = &Config{InstanceProfileLabels: tc.enabled}
+		_, err := updater.UpdateNodeLabel(context.TODO(), tc.workerName)
+		assert.Nil(t, err)
+
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), tc.workerName, metav1.GetOptions{})
+		assert.Nil(t, err)
+		profile, ok := node.Labels[instanceProfileLabelKey]
+		assert.Equal(t, tc.expProfile != "", ok)
+		assert.Equal(t, tc.expProfile, profile)
+		bandwidthLabel, ok := node.Labels[instanceBandwidthLabelKey]
+		assert.Equal(t, tc.expBandwidth != "", ok)
+		assert.Equal(t, tc.expBandwidth, bandwidthLabel)
+	}
+}
+
+func TestUpdateNodeLabelVpcID(t *testing.T) {
+	inVpc := newTestInstance("vpc-worker", "instance-id-1", "us-south-1", "10.0.0.1")
+	inVpc.Vpc = &Vpc{ID: "r006-vpc-1"}
+	riaas := newTestRIAASServer(t, []*Instance{
+		inVpc,
+		newTestInstance("no-vpc-worker", "instance-id-2", "us-south-1", "10.0.0.2"),
+	})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name       string
+		workerName string
+		expVpcID   string
+	}{
+		{name: "vpc present", workerName: "vpc-worker", expVpcID: "r006-vpc-1"},
+		{name: "vpc absent", workerName: "no-vpc-worker"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode(tc.workerName, map[string]string{}), riaas.URL)
+		_, err := updater.UpdateNodeLabel(context.TODO(), tc.workerName)
+		assert.Nil(t, err)
+
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), tc.workerName, metav1.GetOptions{})
+		assert.Nil(t, err)
+		vpcID, ok := node.Labels[vpcIDLabelKey]
+		assert.Equal(t, tc.expVpcID != "", ok)
+		assert.Equal(t, tc.expVpcID, vpcID)
+	}
+}
+
+func TestUpdateNodeLabelRetriesOnConflict(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	node := newTestNode("valid-worker", map[string]string{})
+	node.Annotations = nil
+	updater, clientset := initFakeNodeLabelUpdater(t, node, riaas.URL)
+	updater.Config = &Config{AnnotateLastReconcile: true}
+
+	// The first update conflicts with a concurrent writer which annotated the node.
+	conflicted := false
+	clientset.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		concurrent := node.DeepCopy()
+		concurrent.Annotations = map[string]string{"example.com/other": "value"}
+		assert.Nil(t, clientset.Tracker().Update(schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, concurrent, ""))
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "valid-worker", errors.New("object was modified"))
+	})
+
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 2, countActions(clientset, "update"))
+
+	updated, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid-instance-id", updated.Labels[instanceIDLabelKey])
+	assert.Equal(t, "value", updated.Annotations["example.com/other"])
+	assert.NotEmpty(t, updated.Annotations[lastReconcileAnnotationKey])
+}
+
+func TestUpdateNodeLabelSetsNodeCondition(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	for _, enabled := range []bool{true, false} {
+		t.Logf("Test case: set node condition %v", enabled)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+		updater.Config = &Config{SetNodeCondition: enabled}
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		assert.Nil(t, err)
+		condition := getNodeCondition(node, labelsAppliedConditionType)
+		if enabled {
+			assert.NotNil(t, condition)
+			assert.Equal(t, v1.ConditionTrue, condition.Status)
+			assert.Equal(t, labelsAppliedConditionReason, condition.Reason)
+		} else {
+			assert.Nil(t, condition)
+			assert.Equal(t, 0, countActions(clientset, "patch"))
+		}
+	}
+
+	// A failed reconcile does not set the condition.
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("unknown-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{SetNodeCondition: true}
+	_, err := updater.UpdateNodeLabel(context.TODO(), "unknown-worker")
+	assert.NotNil(t, err)
+	node, _ := clientset.CoreV1().Nodes().Get(context.TODO(), "unknown-worker", metav1.GetOptions{})
+	assert.Nil(t, getNodeCondition(node, labelsAppliedConditionType))
+	assert.Equal(t, 0, countActions(clientset, "patch"))
+}
+
+func TestUpdateNodeLabelAnnotatesPod(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	for _, enabled := range []bool{true, false} {
+		t.Logf("Test case: annotate pod %v", enabled)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "updater-pod", Namespace: "kube-system"}}
+		_, err := clientset.CoreV1().Pods("kube-system").Create(context.TODO(), pod, metav1.CreateOptions{})
+		assert.Nil(t, err)
+		updater.Config = &Config{AnnotatePod: enabled, PodName: "updater-pod", PodNamespace: "kube-system"}
+		_, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+
+		pod, err = clientset.CoreV1().Pods("kube-system").Get(context.TODO(), "updater-pod", metav1.GetOptions{})
+		assert.Nil(t, err)
+		if enabled {
+			assert.Equal(t, "us-south-1", pod.Annotations[zoneAnnotationKey])
+			assert.Equal(t, "us-south", pod.Annotations[regionAnnotationKey])
+		} else {
+			assert.Empty(t, pod.Annotations)
+		}
+	}
+
+	// A missing pod does not fail the update.
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{AnnotatePod: true, PodName: "missing-pod", PodNamespace: "kube-system"}
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+}
+
+func TestUpdateNodeLabelTransformsNodeName(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("Valid-Worker.example.com", map[string]string{}), riaas.URL)
+	_, err := updater.UpdateNodeLabel(context.TODO(), "Valid-Worker.example.com")
+	assert.NotNil(t, err)
+
+	updater.Config = &Config{NodeNameTransforms: []string{NodeNameTransformStripDomain, NodeNameTransformLowercase}}
+	done, err := updater.UpdateNodeLabel(context.TODO(), "Valid-Worker.example.com")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "Valid-Worker.example.com", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid-instance-id", node.Labels[instanceIDLabelKey])
+}
+
+func TestUpdateNodeLabelSkipsDeletingNode(t *testing.T) {
+	requests := 0
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		riaas.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+
+	node := newTestNode("valid-worker", map[string]string{})
+	deletionTimestamp := metav1.Now()
+	node.DeletionTimestamp = &deletionTimestamp
+	updater, clientset := initFakeNodeLabelUpdater(t, node, counting.URL)
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 0, requests)
+	assert.Equal(t, 0, countActions(clientset, "update"))
+}
+
+func TestUpdateNodeLabelMaxLabels(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{MaxLabels: 3}
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.False(t, done)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "more than the maximum of 3")
+	assert.Equal(t, 0, countActions(clientset, "update"))
+
+	updater.Config = &Config{}
+	done, err = updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.True(t, done)
+	assert.Nil(t, err)
+}
+
+func TestGetNodeLabelsLowercaseTopology(t *testing.T) {
+	nodeinfo := &NodeInfo{InstanceID: "valid-instance-id", Zone: "US-South-1", Region: "US-South"}
+	for _, enabled := range []bool{true, false} {
+		t.Logf("Test case: lowercase topology %v", enabled)
+		updater := &VpcNodeLabelUpdater{Config: &Config{LowercaseTopology: enabled}}
+		labels := updater.getNodeLabels(nodeinfo)
+		if enabled {
+			assert.Equal(t, "us-south-1", labels[topologyZoneLabelKey])
+			assert.Equal(t, "us-south", labels[topologyRegionLabelKey])
+			assert.Equal(t, "us-south-1", labels[failureZoneLabelKey])
+			assert.Equal(t, "us-south", labels[failureRegionLabelKey])
+		} else {
+			assert.Equal(t, "US-South-1", labels[topologyZoneLabelKey])
+			assert.Equal(t, "US-South", labels[topologyRegionLabelKey])
+		}
+		assert.Equal(t, "valid-instance-id", labels[instanceIDLabelKey])
+	}
+}
+
+func getNodeCondition(node *v1.Node, conditionType v1.NodeConditionType) *v1.NodeCondition {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == conditionType {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestUpdateNodeLabelMissingZonePolicy(t *testing.T) {
+	zoneless := newTestInstance("valid-worker", "valid-instance-id", "", "10.0.0.1")
+	zoneless.Zone = nil
+	riaas := newTestRIAASServer(t, []*Instance{zoneless})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name      string
+		cfg       *Config
+		expErr    bool
+		expLabels map[string]string
+		absent    []string
+	}{
+		{
+			name:   "default policy fails",
+			cfg:    nil,
+			expErr: true,
+		},
+		{
+			name:   "fail policy",
+			cfg:    &Config{MissingZonePolicy: MissingZoneFail},
+			expErr: true,
+		},
+		{
+			name:      "skip-topology policy",
+			cfg:       &Config{MissingZonePolicy: MissingZoneSkipTopology},
+			expLabels: map[string]string{instanceIDLabelKey: "valid-instance-id", vpcBlockLabelKey: "true"},
+			absent:    []string{topologyZoneLabelKey, topologyRegionLabelKey, failureZoneLabelKey, failureRegionLabelKey},
+		},
+		{
+			name:      "use-override policy",
+			cfg:       &Config{MissingZonePolicy: MissingZoneUseOverride, ZoneOverride: "eu-de-2"},
+			expLabels: map[string]string{instanceIDLabelKey: "valid-instance-id", topologyZoneLabelKey: "eu-de-2", topologyRegionLabelKey: "eu-de"},
+		},
+		{
+			name:   "use-override policy without override",
+			cfg:    &Config{MissingZonePolicy: MissingZoneUseOverride},
+			expErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+		updater.Config = tc.cfg
+		done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, !tc.expErr, done)
+		node, _ := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		for key, value := range tc.expLabels {
+			assert.Equal(t, value, node.Labels[key])
+		}
+		for _, key := range tc.absent {
+			assert.NotContains(t, node.Labels, key)
+		}
+		if tc.expErr {
+			assert.Equal(t, 0, countActions(clientset, "update"))
+		}
+	}
+}
+
+func TestUpdateNodeLabelProvisioningPolicy(t *testing.T) {
+	original := provisioningPollInterval
+	defer func() { provisioningPollInterval = original }()
+	provisioningPollInterval = 10 * time.Millisecond
+
+	// newProvisioningRIAASServer serves the instance as pending for the first pendingLookups
+	// lookups, without its network interface, and as running afterwards.
+	newProvisioningRIAASServer := func(pendingLookups int32) (*httptest.Server, *int32) {
+		var lookups int32
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			instance := newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")
+			if atomic.AddInt32(&lookups, 1) <= pendingLookups {
+				instance.Status = "pending"
+				instance.PrimaryNetworkInterface = nil
+			}
+			_ = json.NewEncoder(w).Encode(&InstanceList{Instances: []*Instance{instance}})
+		})), &lookups
+	}
+
+	testCases := []struct {
+		name           string
+		cfg            *Config
+		pendingLookups int32
+		expErr         bool
+		expLookups     int32
+	}{
+		{
+			name:           "default policy waits until running",
+			cfg:            nil,
+			pendingLookups: 2,
+			expLookups:     3,
+		},
+		{
+			name:           "proceed policy labels the provisioning instance",
+			cfg:            &Config{ProvisioningPolicy: ProvisioningProceed},
+			pendingLookups: 2,
+			expLookups:     1,
+		},
+		{
+			name:           "wait policy times out",
+			cfg:            &Config{ProvisioningPolicy: ProvisioningWait, ProvisioningWaitTimeout: 50 * time.Millisecond},
+			pendingLookups: 1000,
+			expErr:         true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		riaas, lookups := newProvisioningRIAASServer(tc.pendingLookups)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+		updater.Config = tc.cfg
+		done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		riaas.Close()
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, !tc.expErr, done)
+		if tc.expErr {
+			assert.Equal(t, 0, countActions(clientset, "update"))
+			continue
+		}
+		assert.Equal(t, tc.expLookups, atomic.LoadInt32(lookups))
+		node, _ := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		assert.Equal(t, "valid-instance-id", node.Labels[instanceIDLabelKey])
+		assert.Equal(t, "us-south-1", node.Labels[topologyZoneLabelKey])
+	}
+}
+
+func TestUpdateNodeLabelTopologyConflictPolicy(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name      string
+		cfg       *Config
+		labels    map[string]string
+		expErr    bool
+		expLabels map[string]string
+	}{
+		{
+			name:      "default policy overwrites",
+			cfg:       nil,
+			labels:    map[string]string{topologyZoneLabelKey: "us-south-2"},
+			expLabels: map[string]string{topologyZoneLabelKey: "us-south-1", failureZoneLabelKey: "us-south-1"},
+		},
+		{
+			name:      "overwrite policy",
+			cfg:       &Config{TopologyConflictPolicy: TopologyConflictOverwrite},
+			labels:    map[string]string{topologyZoneLabelKey: "us-south-2"},
+			expLabels: map[string]string{topologyZoneLabelKey: "us-south-1"},
+		},
+		{
+			name:      "skip-if-present policy keeps existing labels",
+			cfg:       &Config{TopologyConflictPolicy: TopologyConflictSkipIfPresent},
+			labels:    map[string]string{topologyZoneLabelKey: "us-south-2"},
+			expLabels: map[string]string{topologyZoneLabelKey: "us-south-2", failureZoneLabelKey: "us-south-1", instanceIDLabelKey: "valid-instance-id"},
+		},
+		{
+			name:   "error-on-mismatch policy fails",
+			cfg:    &Config{TopologyConflictPolicy: TopologyConflictErrorOnMismatch},
+			labels: map[string]string{topologyZoneLabelKey: "us-south-2"},
+			expErr: true,
+		},
+		{
+			name:      "error-on-mismatch policy with matching labels",
+			cfg:       &Config{TopologyConflictPolicy: TopologyConflictErrorOnMismatch},
+			labels:    map[string]string{topologyZoneLabelKey: "us-south-1"},
+			expLabels: map[string]string{topologyZoneLabelKey: "us-south-1", instanceIDLabelKey: "valid-instance-id"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", tc.labels), riaas.URL)
+		updater.Config = tc.cfg
+		done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, !tc.expErr, done)
+		if tc.expErr {
+			assert.Equal(t, 0, countActions(clientset, "update"))
+			continue
+		}
+		node, _ := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		for key, value := range tc.expLabels {
+			assert.Equal(t, value, node.Labels[key])
+		}
+	}
+}
+
+func TestUpdateNodeLabelProviderIDCheck(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name       string
+		providerID string
+		cfg        *Config
+		expErr     bool
+		expWarning bool
+	}{
+		{name: "no provider ID", providerID: ""},
+		{name: "matching provider ID", providerID: "ibm://account-id///cluster-id/valid-instance-id"},
+		{name: "matching provider ID with fail policy", providerID: "ibm://account-id///cluster-id/valid-instance-id", cfg: &Config{ProviderIDMismatchPolicy: ProviderIDMismatchFail}},
+		{name: "mismatching provider ID warns by default", providerID: "ibm://account-id///cluster-id/other-instance-id", expWarning: true},
+		{name: "mismatching provider ID with fail policy", providerID: "ibm://account-id///cluster-id/other-instance-id", cfg: &Config{ProviderIDMismatchPolicy: ProviderIDMismatchFail}, expErr: true},
+		{name: "foreign provider ID is ignored", providerID: "aws:///us-east-1a/i-0123456789", cfg: &Config{ProviderIDMismatchPolicy: ProviderIDMismatchFail}},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		logger, buf := newBufferLogger()
+		node := newTestNode("valid-worker", map[string]string{})
+		node.Spec.ProviderID = tc.providerID
+		updater, clientset := initFakeNodeLabelUpdater(t, node, riaas.URL)
+		updater.Logger = logger
+		updater.Config = tc.cfg
+		done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, !tc.expErr, done)
+		assert.Equal(t, tc.expWarning, strings.Contains(buf.String(), "Instance does not match the node provider ID"))
+		if tc.expErr {
+			assert.Equal(t, 0, countActions(clientset, "update"))
+		}
+	}
+}
+
+func TestInstanceIDLabelKeyOverride(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	cfg := &Config{InstanceIDLabelKey: "example.com/instance-id"}
+
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = cfg
+	_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "valid-instance-id", node.Labels["example.com/instance-id"])
+	assert.NotContains(t, node.Labels, instanceIDLabelKey)
+	// The check uses the same key as the write.
+	assert.True(t, cfg.CheckIfRequiredLabelsPresent(node.Labels))
+	assert.False(t, CheckIfRequiredLabelsPresent(node.Labels))
+	assert.False(t, (&Config{}).CheckIfRequiredLabelsPresent(node.Labels))
+}
+
+func TestLabelKeyOverrides(t *testing.T) {
+	bandwidth := int64(4000)
+	instance := newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")
+	instance.Vpc = &Vpc{ID: "r006-vpc-1"}
+	instance.Profile = &Profile{Name: "bx2-4x16"}
+	instance.Bandwidth = &bandwidth
+	riaas := newTestRIAASServer(t, []*Instance{instance})
+	defer riaas.Close()
+	cfg := &Config{InstanceProfileLabels: true, RequireVpcIDLabel: true, LabelKeys: map[string]string{
+		vpcBlockLabelKey:          "example.com/vpc-block",
+		topologyZoneLabelKey:      "example.com/zone",
+		topologyRegionLabelKey:    "example.com/region",
+		vpcIDLabelKey:             "example.com/vpc-id",
+		instanceProfileLabelKey:   "example.com/profile",
+		instanceBandwidthLabelKey: "example.com/bandwidth",
+	}}
+
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = cfg
+	_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "true", node.Labels["example.com/vpc-block"])
+	assert.Equal(t, "us-south-1", node.Labels["example.com/zone"])
+	assert.Equal(t, "us-south", node.Labels["example.com/region"])
+	assert.Equal(t, "us-south-1", node.Labels[failureZoneLabelKey])
+	assert.NotContains(t, node.Labels, vpcBlockLabelKey)
+	assert.NotContains(t, node.Labels, topologyZoneLabelKey)
+	assert.Equal(t, "r006-vpc-1", node.Labels["example.com/vpc-id"])
+	assert.Equal(t, "bx2-4x16", node.Labels["example.com/profile"])
+	assert.Equal(t, "4000", node.Labels["example.com/bandwidth"])
+	assert.NotContains(t, node.Labels, vpcIDLabelKey)
+	assert.NotContains(t, node.Labels, instanceProfileLabelKey)
+	assert.NotContains(t, node.Labels, instanceBandwidthLabelKey)
+	assert.Contains(t, cfg.ManagedLabelKeys(), "example.com/vpc-id")
+	assert.Contains(t, cfg.ManagedLabelKeys(), "example.com/bandwidth")
+	// The check uses the same keys as the write.
+	assert.True(t, cfg.CheckIfRequiredLabelsPresent(node.Labels))
+	assert.False(t, (&Config{}).CheckIfRequiredLabelsPresent(node.Labels))
+	delete(node.Labels, "example.com/vpc-id")
+	assert.False(t, cfg.CheckIfRequiredLabelsPresent(node.Labels))
+}
@@ -0,0 +1,75 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+)
+
+// SkipReason is why a node was left unlabeled.
+type SkipReason string
+
+const (
+	// SkipReasonAlreadyLabeled is used when the node already carries the labels.
+	SkipReasonAlreadyLabeled SkipReason = "already-labeled"
+	// SkipReasonDeleting is used when the node is being deleted.
+	SkipReasonDeleting SkipReason = "deleting"
+	// SkipReasonNotOptedIn is used when opt-in is enabled and the node is not annotated.
+	SkipReasonNotOptedIn SkipReason = "not-opted-in"
+	// SkipReasonNotVPC is used when the cluster does not run on VPC infrastructure.
+	SkipReasonNotVPC SkipReason = "not-vpc"
+)
+
+// skipCounts counts the skipped nodes by reason.
+var skipCounts = struct {
+	sync.Mutex
+	counts map[SkipReason]int64
+}{counts: map[SkipReason]int64{}}
+
+// RecordSkip logs msg with the reason the node was skipped and counts it.
+func RecordSkip(logger *zap.Logger, reason SkipReason, msg string, fields ...zap.Field) {
+	skipCounts.Lock()
+	skipCounts.counts[reason]++
+	skipCounts.Unlock()
+	logger.Info(msg, append(fields, zap.String("skipReason", string(reason)))...)
+}
+
+// SkipCounts returns a copy of the number of skipped nodes by reason.
+func SkipCounts() map[SkipReason]int64 {
+	skipCounts.Lock()
+	defer skipCounts.Unlock()
+	counts := make(map[SkipReason]int64, len(skipCounts.counts))
+	for reason, count := range skipCounts.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// SkipReason returns why the node needs no labeling before its instance is looked up, or empty if it does.
+func (cfg *Config) SkipReason(node *v1.Node) SkipReason {
+	if !cfg.NeedsRelabel(node) {
+		return SkipReasonAlreadyLabeled
+	}
+	if !cfg.IsOptedIn(node) {
+		return SkipReasonNotOptedIn
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigSkipReason(t *testing.T) {
+	labeled := map[string]string{vpcBlockLabelKey: "true", instanceIDLabelKey: "valid-instance-id"}
+	testCases := []struct {
+		name      string
+		cfg       *Config
+		labels    map[string]string
+		expReason SkipReason
+	}{
+		{name: "unlabeled", cfg: &Config{}, labels: map[string]string{}},
+		{name: "already labeled", cfg: &Config{}, labels: labeled, expReason: SkipReasonAlreadyLabeled},
+		{name: "not opted in", cfg: &Config{OptIn: true}, labels: map[string]string{}, expReason: SkipReasonNotOptedIn},
+		{name: "labeled and not opted in", cfg: &Config{OptIn: true}, labels: labeled, expReason: SkipReasonAlreadyLabeled},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		assert.Equal(t, tc.expReason, tc.cfg.SkipReason(newTestNode("valid-worker", tc.labels)))
+	}
+}
+
+func TestUpdateNodeLabelRecordsSkipReason(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	deleting := newTestNode("valid-worker", map[string]string{})
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	testCases := []struct {
+		name      string
+		cfg       *Config
+		labeled   bool
+		deleting  bool
+		expReason SkipReason
+	}{
+		{name: "deleting", cfg: &Config{}, deleting: true, expReason: SkipReasonDeleting},
+		{name: "not opted in", cfg: &Config{OptIn: true}, expReason: SkipReasonNotOptedIn},
+		{name: "labels already match", cfg: &Config{}, labeled: true, expReason: SkipReasonAlreadyLabeled},
+		{name: "labeled", cfg: &Config{}},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		node := newTestNode("valid-worker", map[string]string{})
+		if tc.deleting {
+			node = deleting
+		}
+		updater, _ := initFakeNodeLabelUpdater(t, node, riaas.URL)
+		updater.Config = tc.cfg
+		if tc.labeled {
+			_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+			assert.Nil(t, err)
+		}
+		logger, buf := newBufferLogger()
+		updater.Logger = logger
+		before := SkipCounts()
+		_, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+
+		after := SkipCounts()
+		for _, reason := range []SkipReason{SkipReasonAlreadyLabeled, SkipReasonDeleting, SkipReasonNotOptedIn, SkipReasonNotVPC} {
+			expDelta := int64(0)
+			if reason == tc.expReason {
+				expDelta = 1
+			}
+			assert.Equal(t, expDelta, after[reason]-before[reason], string(reason))
+		}
+		assert.Equal(t, tc.expReason != "", strings.Contains(buf.String(), `"skipReason":"`+string(tc.expReason)+`"`))
+	}
+}
@@ -0,0 +1,77 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeLabelSnapshot holds the current values of the managed label keys on a node. Keys which
+// are not set on the node map to nil.
+type NodeLabelSnapshot struct {
+	NodeName string             `json:"node"`
+	Labels   map[string]*string `json:"labels"`
+}
+
+// ManagedLabelKeys returns the label keys the updater sets, sorted.
+func (cfg *Config) ManagedLabelKeys() []string {
+	keys := []string{
+		cfg.labelKey(workerIDLabelKey),
+		cfg.labelKey(instanceIDLabelKey),
+		cfg.labelKey(vpcBlockLabelKey),
+		cfg.labelKey(failureRegionLabelKey),
+		cfg.labelKey(failureZoneLabelKey),
+		cfg.labelKey(topologyRegionLabelKey),
+		cfg.labelKey(topologyZoneLabelKey),
+		cfg.labelKey(vpcIDLabelKey),
+		cfg.labelKey(instanceProfileLabelKey),
+	}
+	if cfg.InstanceProfileLabels {
+		keys = append(keys, cfg.labelKey(instanceBandwidthLabelKey))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SnapshotManagedLabels lists the nodes and returns the current values of the managed label keys
+// on each of them, sorted by node name. It makes no changes and only needs list access to nodes.
+func SnapshotManagedLabels(ctx context.Context, k8sClient kubernetes.Interface, cfg *Config) ([]NodeLabelSnapshot, error) {
+	nodes, err := k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	keys := cfg.ManagedLabelKeys()
+	snapshots := make([]NodeLabelSnapshot, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		snapshot := NodeLabelSnapshot{NodeName: node.Name, Labels: make(map[string]*string, len(keys))}
+		for _, key := range keys {
+			if value, ok := node.Labels[key]; ok {
+				snapshot.Labels[key] = &value
+			} else {
+				snapshot.Labels[key] = nil
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].NodeName < snapshots[j].NodeName })
+	return snapshots, nil
+}
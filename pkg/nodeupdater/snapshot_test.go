@@ -0,0 +1,79 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSnapshotManagedLabels(t *testing.T) {
+	labeled := newTestNode("labeled-worker", map[string]string{
+		workerIDLabelKey:       "instance-1",
+		instanceIDLabelKey:     "instance-1",
+		vpcBlockLabelKey:       "true",
+		failureRegionLabelKey:  "us-south",
+		failureZoneLabelKey:    "us-south-1",
+		topologyRegionLabelKey: "us-south",
+		topologyZoneLabelKey:   "us-south-1",
+		"kubernetes.io/os":     "linux",
+	})
+	partial := newTestNode("partial-worker", map[string]string{instanceIDLabelKey: "instance-2", topologyZoneLabelKey: ""})
+	unlabeled := newTestNode("a-unlabeled-worker", nil)
+	clientset := fake.NewSimpleClientset(labeled, partial, unlabeled)
+
+	cfg := &Config{}
+	snapshots, err := SnapshotManagedLabels(context.TODO(), clientset, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(snapshots))
+	assert.Equal(t, []string{"a-unlabeled-worker", "labeled-worker", "partial-worker"},
+		[]string{snapshots[0].NodeName, snapshots[1].NodeName, snapshots[2].NodeName})
+
+	// Every managed key is reported, missing ones as nil; other labels are left out.
+	for _, snapshot := range snapshots {
+		assert.Equal(t, len(cfg.ManagedLabelKeys()), len(snapshot.Labels))
+	}
+	for _, value := range snapshots[0].Labels {
+		assert.Nil(t, value)
+	}
+	assert.Equal(t, "us-south-1", *snapshots[1].Labels[topologyZoneLabelKey])
+	assert.NotContains(t, snapshots[1].Labels, "kubernetes.io/os")
+	assert.Equal(t, "instance-2", *snapshots[2].Labels[instanceIDLabelKey])
+	assert.Equal(t, "", *snapshots[2].Labels[topologyZoneLabelKey])
+	assert.Nil(t, snapshots[2].Labels[vpcBlockLabelKey])
+
+	out, err := json.Marshal(snapshots[2])
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"node":"partial-worker"`)
+	assert.Contains(t, string(out), `"`+vpcBlockLabelKey+`":null`)
+	assert.Contains(t, string(out), `"`+topologyZoneLabelKey+`":""`)
+
+	// The configured instance ID label key is managed instead of the default one.
+	keys := (&Config{InstanceIDLabelKey: "example.com/instance-id"}).ManagedLabelKeys()
+	assert.Contains(t, keys, "example.com/instance-id")
+	assert.NotContains(t, keys, instanceIDLabelKey)
+
+	// Snapshots never write to the nodes.
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "list", action.GetVerb())
+	}
+}
@@ -0,0 +1,121 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	maxRedirects   = 10
+	defaultDNSPort = "53"
+	dialTimeout    = 30 * time.Second
+	dialKeepAlive  = 30 * time.Second
+)
+
+// NewRiaasHTTPClient returns the HTTP client used for RIAAS calls, configured from cfg.
+func NewRiaasHTTPClient(cfg *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLSClientCert != "" || cfg.TLSClientKey != "" {
+		if cfg.TLSClientCert == "" || cfg.TLSClientKey == "" {
+			return nil, errors.New("both TLS client certificate and key must be provided for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSClientCert, cfg.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %v", err)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	if cfg.DNSServer != "" {
+		dnsServer, err := getDNSServerAddress(cfg.DNSServer)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: dialKeepAlive,
+			Resolver:  newDNSResolver(dnsServer),
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{Transport: transport, CheckRedirect: newCheckRedirect(cfg.RedirectAuthPolicy)}, nil
+}
+
+// newCheckRedirect returns the redirect policy of the RIAAS client. Redirects to another host,
+// for which the Authorization header would be dropped, are refused with an error unless the
+// policy is to preserve the header.
+func newCheckRedirect(policy string) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		initial := via[0]
+		if req.URL.Hostname() == initial.URL.Hostname() {
+			return nil
+		}
+		auth := initial.Header.Get("Authorization")
+		if auth == "" {
+			return nil
+		}
+		if policy != RedirectAuthPreserve {
+			return fmt.Errorf("refusing redirect from RIAAS host %s to %s as it would drop the Authorization header, set RIAAS_REDIRECT_AUTH=%s to follow it", initial.URL.Host, req.URL.Host, RedirectAuthPreserve)
+		}
+		req.Header.Set("Authorization", auth)
+		return nil
+	}
+}
+
+// newDNSResolver returns a resolver which sends all DNS queries to dnsServer.
+func newDNSResolver(dnsServer string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: dialTimeout}
+			return d.DialContext(ctx, network, dnsServer)
+		},
+	}
+}
+
+// getDNSServerAddress validates dnsServer and adds the default DNS port if none is given.
+func getDNSServerAddress(dnsServer string) (string, error) {
+	host, port, err := net.SplitHostPort(dnsServer)
+	if err != nil {
+		host, port = dnsServer, defaultDNSPort
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid DNS server %q, expected an IP address with optional port", dnsServer)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// getHTTPClient returns the client used for RIAAS calls.
+func (c *VpcNodeLabelUpdater) getHTTPClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
@@ -0,0 +1,215 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDNSServerAddress(t *testing.T) {
+	testCases := []struct {
+		name       string
+		dnsServer  string
+		expAddress string
+		expErr     bool
+	}{
+		{
+			name:       "ip without port",
+			dnsServer:  "10.0.0.10",
+			expAddress: "10.0.0.10:53",
+		},
+		{
+			name:       "ip with port",
+			dnsServer:  "10.0.0.10:5353",
+			expAddress: "10.0.0.10:5353",
+		},
+		{
+			name:      "hostname",
+			dnsServer: "dns.example.com",
+			expErr:    true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		address, err := getDNSServerAddress(tc.dnsServer)
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, tc.expAddress, address)
+	}
+}
+
+func TestNewRiaasHTTPClientDNSServer(t *testing.T) {
+	// A UDP listener stands in for the DNS server; receiving a query proves the custom resolver is used.
+	dnsServer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer dnsServer.Close()
+	queries := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		if _, _, err := dnsServer.ReadFrom(buf); err == nil {
+			queries <- struct{}{}
+		}
+	}()
+
+	client, err := NewRiaasHTTPClient(&Config{DNSServer: dnsServer.LocalAddr().String()})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://riaas.split-horizon.test/v1/instances", nil)
+	_, err = client.Do(req)
+	assert.NotNil(t, err)
+
+	select {
+	case <-queries:
+	case <-time.After(time.Second):
+		t.Fatal("expected a DNS query to be sent to the configured server")
+	}
+
+	_, err = NewRiaasHTTPClient(&Config{DNSServer: "not-an-ip"})
+	assert.NotNil(t, err)
+	client, err = NewRiaasHTTPClient(&Config{})
+	assert.Nil(t, err)
+	assert.NotNil(t, client.Transport)
+}
+
+// writeTestKeyPair writes a self-signed certificate and key to dir and returns their paths.
+func writeTestKeyPair(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vpc-node-label-updater"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestNewRiaasHTTPClientMutualTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	certFile, keyFile := writeTestKeyPair(t, t.TempDir())
+
+	// trustServer makes the client trust the test server certificate.
+	trustServer := func(client *http.Client) {
+		transport := client.Transport.(*http.Transport)
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLSClientConfig.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	}
+
+	// With the client certificate the request succeeds.
+	client, err := NewRiaasHTTPClient(&Config{TLSClientCert: certFile, TLSClientKey: keyFile})
+	assert.Nil(t, err)
+	trustServer(client)
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	if resp != nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// Without it the server rejects the handshake.
+	client, err = NewRiaasHTTPClient(&Config{})
+	assert.Nil(t, err)
+	trustServer(client)
+	_, err = client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	// Only one of certificate and key fails fast.
+	_, err = NewRiaasHTTPClient(&Config{TLSClientCert: certFile})
+	assert.NotNil(t, err)
+	_, err = NewRiaasHTTPClient(&Config{TLSClientKey: keyFile})
+	assert.NotNil(t, err)
+	_, err = NewRiaasHTTPClient(&Config{TLSClientCert: certFile, TLSClientKey: certFile})
+	assert.NotNil(t, err)
+}
+
+func TestRiaasHTTPClientRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "valid-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	// Redirect to the same server under another host name, which drops the Authorization header.
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+	gateway := httptest.NewServer(http.RedirectHandler("http://localhost:"+port+"/v1/instances", http.StatusTemporaryRedirect))
+	defer gateway.Close()
+
+	testCases := []struct {
+		name      string
+		policy    string
+		expErr    string
+		expStatus int
+	}{
+		{name: "default policy refuses", policy: "", expErr: "refusing redirect"},
+		{name: "refuse policy", policy: RedirectAuthRefuse, expErr: "refusing redirect"},
+		{name: "preserve policy", policy: RedirectAuthPreserve, expStatus: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		client, err := NewRiaasHTTPClient(&Config{RedirectAuthPolicy: tc.policy})
+		assert.Nil(t, err)
+		req, err := http.NewRequest(http.MethodGet, gateway.URL+"/v1/instances", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", "valid-token")
+		resp, err := client.Do(req)
+		if tc.expErr != "" {
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.expErr)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expStatus, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
@@ -18,6 +18,7 @@
 package nodeupdater
 
 import (
+	"context"
 	"encoding/json"
 	errors "errors"
 	"fmt"
@@ -25,13 +26,24 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/IBM/go-sdk-core/v5/core"
 	"github.com/IBM/ibmcloud-volume-interface/provider/iam"
 	sp "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	secretconfig "github.com/IBM/secret-utils-lib/pkg/config"
 	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	secretprovider "github.com/IBM/secret-utils-lib/pkg/secret_provider"
+	secretutils "github.com/IBM/secret-utils-lib/pkg/utils"
 	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
@@ -44,31 +56,103 @@ const (
 	vpcGeneration          = "2"
 	vpcRiaasVersion        = "2020-01-01"
 	maxAttempts            = 30
-	retryInterval          = "10s"
-	vpcBlockLabelKey       = "vpc-block-csi-driver-labels"
+	// maxRetryMaxAttempts bounds RETRY_MAX_ATTEMPTS and the RETRY_ATTEMPTS_* overrides.
+	maxRetryMaxAttempts = 1000
+	retryInterval       = "10s"
+	// defaultRetryInterval is used when retryInterval cannot be parsed.
+	defaultRetryInterval = 10 * time.Second
+	vpcBlockLabelKey     = "vpc-block-csi-driver-labels"
+	// instanceProfileLabelKey and instanceBandwidthLabelKey carry the instance profile and its bandwidth in Mbps.
+	instanceProfileLabelKey   = "ibm-cloud.kubernetes.io/instance-profile"
+	instanceBandwidthLabelKey = "ibm-cloud.kubernetes.io/vpc-instance-bandwidth"
+	// vpcIDLabelKey carries the ID of the VPC the instance belongs to.
+	vpcIDLabelKey = "ibm-cloud.kubernetes.io/vpc-id"
+	// instanceIDAnnotationKey is an optional node annotation carrying the VPC instance ID, e.g. set from cloud-init.
+	instanceIDAnnotationKey = "vpc-node-label-updater/instance-id"
+	// zoneAnnotationKey and regionAnnotationKey record the discovered zone and region on the updater pod.
+	zoneAnnotationKey   = "vpc-node-label-updater/zone"
+	regionAnnotationKey = "vpc-node-label-updater/region"
+	// labelsAppliedConditionType is the node condition set after the labels are applied.
+	labelsAppliedConditionType   = "VPCLabelsApplied"
+	labelsAppliedConditionReason = "LabelsApplied"
+	// lastReconcileAnnotationKey records the RFC3339 time of the last successful label update.
+	lastReconcileAnnotationKey = "vpc-node-label-updater/last-reconcile"
+	// bootIDAnnotationKey records the boot ID of the node at the last successful label update.
+	bootIDAnnotationKey = "vpc-node-label-updater/boot-id"
+	// managedKeysAnnotationKey records the comma-separated label keys applied by the updater, for cleanup.
+	managedKeysAnnotationKey = "vpc-node-label-updater/managed-keys"
+	// optInAnnotationKey opts a node in to labeling when OPT_IN_ANNOTATION is set.
+	optInAnnotationKey = "vpc-node-label-updater/enabled"
+	// credentialKeyAnnotationKey selects the secret key holding the credentials to use for the node.
+	credentialKeyAnnotationKey = "vpc-node-label-updater/credential-key"
+	// providerIDPrefix is the scheme of the provider ID set on IBM Cloud nodes.
+	providerIDPrefix = "ibm://"
+	// instanceStatusRunning is the status of an instance which has finished provisioning.
+	instanceStatusRunning = "running"
 )
 
+// labelKeyEnvs maps the environment variable overriding the key of each managed label to its default key.
+// The instance ID label key is overridden with INSTANCE_ID_LABEL_KEY, see Config.GetInstanceIDLabelKey.
+var labelKeyEnvs = map[string]string{
+	"WORKER_ID_LABEL_KEY":          workerIDLabelKey,
+	"VPC_BLOCK_LABEL_KEY":          vpcBlockLabelKey,
+	"FAILURE_REGION_LABEL_KEY":     failureRegionLabelKey,
+	"FAILURE_ZONE_LABEL_KEY":       failureZoneLabelKey,
+	"TOPOLOGY_REGION_LABEL_KEY":    topologyRegionLabelKey,
+	"TOPOLOGY_ZONE_LABEL_KEY":      topologyZoneLabelKey,
+	"VPC_ID_LABEL_KEY":             vpcIDLabelKey,
+	"INSTANCE_PROFILE_LABEL_KEY":   instanceProfileLabelKey,
+	"INSTANCE_BANDWIDTH_LABEL_KEY": instanceBandwidthLabelKey,
+}
+
+// provisioningStatuses are the statuses RIAAS reports while an instance is provisioning.
+var provisioningStatuses = map[string]bool{"pending": true, "starting": true}
+
+// provisioningPollInterval is the interval between lookups of a provisioning instance.
+var provisioningPollInterval = 5 * time.Second
+
+// secretProviderFactory creates the secret provider used to read the RIAAS endpoint and IAM token.
+type secretProviderFactory func(k8sClient *k8s_utils.KubernetesClient, providerArgs map[string]string) (secretprovider.SecretProviderInterface, error)
+
+// newSecretProvider is the factory used by ReadSecretConfiguration, replaceable in tests.
+var newSecretProvider secretProviderFactory = func(k8sClient *k8s_utils.KubernetesClient, providerArgs map[string]string) (secretprovider.SecretProviderInterface, error) {
+	return sp.NewSecretProvider(k8sClient, providerArgs)
+}
+
 // ReadSecretConfiguration ...
 func ReadSecretConfiguration(k8sClient *k8s_utils.KubernetesClient, ctxLogger *zap.Logger) (*StorageSecretConfig, error) {
+	return ReadSecretConfigurationForNode(context.TODO(), k8sClient, nil, &Config{}, ctxLogger)
+}
+
+// ReadSecretConfigurationForNode reads the secret configuration using the credential set selected by the
+// node's credential annotation, if it names one of the configured credential keys, or the default credentials otherwise.
+// Fetches failing with a transient error are retried like other requests until ctx is done.
+func ReadSecretConfigurationForNode(ctx context.Context, k8sClient *k8s_utils.KubernetesClient, node *v1.Node, cfg *Config, ctxLogger *zap.Logger) (*StorageSecretConfig, error) {
 	ctxLogger.Info("Fetching secret configuration.")
-	providerType := map[string]string{
-		sp.ProviderType: sp.VPC,
-	}
-	spObject, err := sp.NewSecretProvider(k8sClient, providerType)
+	providerArgs := getSecretProviderArgs(node, cfg.CredentialKeys, ctxLogger)
+	spObject, err := initSecretProvider(ctx, k8sClient, providerArgs, cfg.GetSecretProviderTimeout(), ctxLogger)
 	if err != nil {
 		ctxLogger.Error("Error initializing secret provider", zap.Error(err))
 		return nil, err
 	}
 
-	riaasURL, err := spObject.GetRIAASEndpoint(false)
+	var riaasURL string
+	err = retrySecretFetch(ctx, cfg, ctxLogger, func() (fetchErr error) {
+		riaasURL, fetchErr = spObject.GetRIAASEndpoint(false)
+		return fetchErr
+	})
 	if err != nil {
 		ctxLogger.Error("Error fetching RIAAS endpoint", zap.Error(err))
 		return nil, err
 	}
+	if strings.TrimSpace(riaasURL) == "" {
+		ctxLogger.Error("Error fetching RIAAS endpoint", zap.Error(ErrEmptyRIAASEndpoint))
+		return nil, ErrEmptyRIAASEndpoint
+	}
 
 	// Correct if the G2EndpointURL is of the form "http://".
 	riaasURL = getEndpointURL(riaasURL, ctxLogger)
-	riaasInstanceURL, err := url.Parse(fmt.Sprintf("%s/v1/instances?generation=%s&version=%s", riaasURL, vpcGeneration, vpcRiaasVersion))
+	riaasInstanceURL, err := getRiaasInstanceURL(riaasURL, cfg.RiaasPathPrefix, cfg.PageLimit)
 	if err != nil {
 		ctxLogger.Error("Failed to parse riassInstanceURL", zap.Error(err))
 		return nil, err
@@ -77,23 +161,274 @@ func ReadSecretConfiguration(k8sClient *k8s_utils.KubernetesClient, ctxLogger *z
 		RiaasEndpointURL: riaasInstanceURL,
 	}
 
-	accessToken, _, err := spObject.GetDefaultIAMToken(false, "vpc-node-label-updater")
+	var accessToken string
+	err = retrySecretFetch(ctx, cfg, ctxLogger, func() (fetchErr error) {
+		accessToken, fetchErr = getIAMToken(spObject, cfg, false)
+		return fetchErr
+	})
 	if err != nil {
 		ctxLogger.Error("Failed to Get IAM access token", zap.Error(err))
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrIAMToken, err)
 	}
 	storageSecretConfig.IAMAccessToken = accessToken
+	storageSecretConfig.RefreshIAMAccessToken = func() (string, error) {
+		return getIAMToken(spObject, cfg, true)
+	}
 	return storageSecretConfig, nil
 }
 
+// getIAMToken returns an IAM token using the configured IAM auth mode, bypassing the provider's cached token
+// if freshTokenRequired is set.
+func getIAMToken(spObject secretprovider.SecretProviderInterface, cfg *Config, freshTokenRequired bool) (string, error) {
+	if cfg.IAMAuthMode != IAMAuthModeTrustedProfile {
+		accessToken, _, err := spObject.GetDefaultIAMToken(freshTokenRequired, cfg.GetIAMServiceName())
+		return accessToken, err
+	}
+	return getTrustedProfileToken(cfg)
+}
+
+// getTrustedProfileToken exchanges the compute resource token of the pod for an IAM token of the trusted profile.
+// The secret provider cannot be used for this: unless the secret selects PODIDENTITY it sends the profile ID to
+// IAM as an API key. Every call exchanges a fresh token.
+func getTrustedProfileToken(cfg *Config) (string, error) {
+	if cfg.TrustedProfileID == "" {
+		return "", errors.New("trusted profile IAM auth mode requires TRUSTED_PROFILE_ID")
+	}
+	authenticator := &core.ContainerAuthenticator{
+		IAMProfileID:    cfg.TrustedProfileID,
+		CRTokenFilename: cfg.GetTrustedProfileCRTokenFile(),
+		URL:             cfg.GetTrustedProfileIAMURL(),
+	}
+	resp, err := authenticator.RequestToken()
+	if err != nil {
+		// The authentication error hides the cause from errors.As, unwrap it so connection failures are retried.
+		var authErr *core.AuthenticationError
+		if errors.As(err, &authErr) && authErr.Err != nil {
+			return "", authErr.Err
+		}
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("IAM returned no access token for the trusted profile")
+	}
+	return resp.AccessToken, nil
+}
+
+// retrySecretFetch calls fetch until it succeeds or fails with an error which is not transient, such as bad
+// credentials, with the configured attempts and interval of ErrorRetry.
+func retrySecretFetch(ctx context.Context, cfg *Config, logger *zap.Logger, fetch func() error) error {
+	return cfg.ErrorRetry(ctx, logger, func() (error, bool) {
+		err := fetch()
+		return err, !isTransientSecretError(err)
+	})
+}
+
+// transientSecretErrnos are the system call errors of a connection failure.
+var transientSecretErrnos = []error{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ETIMEDOUT}
+
+// isTransientSecretError reports whether a secret provider error is a connection failure worth retrying.
+// A failed DNS lookup is only transient if it timed out.
+func isTransientSecretError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, errno := range transientSecretErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	var secretErr secretutils.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	case errors.As(err, &opErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	case errors.As(err, &secretErr):
+		return isTransientBackendError(secretErr.BackendError)
+	}
+	return classifyError(err) == errorClassConnection
+}
+
+// isTransientBackendError reports whether the backend error of a secret provider error, which the provider
+// keeps only as its message, is the message of one of the connection failures isTransientSecretError detects.
+func isTransientBackendError(msg string) bool {
+	for _, transient := range append([]error{io.ErrUnexpectedEOF, os.ErrDeadlineExceeded}, transientSecretErrnos...) {
+		if strings.HasSuffix(msg, transient.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// initSecretProvider initializes the secret provider, failing with ErrSecretProviderTimeout if it takes longer than timeout
+// and with the context error if ctx is done first.
+func initSecretProvider(ctx context.Context, k8sClient *k8s_utils.KubernetesClient, providerArgs map[string]string, timeout time.Duration, logger *zap.Logger) (secretprovider.SecretProviderInterface, error) {
+	type result struct {
+		provider secretprovider.SecretProviderInterface
+		err      error
+	}
+	start := time.Now()
+	done := make(chan result, 1)
+	factory := newSecretProvider
+	go func() {
+		provider, err := factory(k8sClient, providerArgs)
+		done <- result{provider: provider, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		logger.Info("Secret provider initialization finished", zap.Duration("duration", time.Since(start)), zap.Bool("success", res.err == nil))
+		return res.provider, res.err
+	case <-time.After(timeout):
+		logger.Error("Secret provider initialization timed out", zap.Duration("duration", time.Since(start)))
+		return nil, fmt.Errorf("%w after %s", ErrSecretProviderTimeout, timeout)
+	case <-ctx.Done():
+		logger.Warn("Secret provider initialization interrupted", zap.Duration("duration", time.Since(start)), zap.Error(ctx.Err()))
+		return nil, ctx.Err()
+	}
+}
+
+// vpcProviderTypes are the storage secret provider types of VPC infrastructure.
+var vpcProviderTypes = []string{"g2", "gc"}
+
+// IsVPCInfrastructure reports whether the storage secret describes VPC infrastructure. It returns
+// false only when the secret is readable and has no VPC section or names a non-VPC provider type;
+// otherwise the environment is assumed to be VPC and the secret provider reports any problem.
+func IsVPCInfrastructure(k8sClient *k8s_utils.KubernetesClient, logger *zap.Logger) bool {
+	data, err := k8s_utils.GetSecretData(*k8sClient, secretutils.STORAGE_SECRET_STORE_SECRET, secretutils.SECRET_STORE_FILE)
+	if err != nil {
+		logger.Info("Storage secret is not readable, assuming VPC infrastructure", zap.Error(err))
+		return true
+	}
+	secretConfig, err := secretconfig.ParseConfig(logger, data)
+	if err != nil {
+		return true
+	}
+	vpcConfig := secretConfig.VPC
+	if vpcConfig == nil || (!vpcConfig.Enabled && vpcConfig.G2EndpointURL == "" && vpcConfig.EndpointURL == "" && vpcConfig.VPCBlockProviderType == "") {
+		logger.Info("Storage secret has no VPC configuration")
+		return false
+	}
+	providerType := vpcConfig.VPCBlockProviderType
+	if providerType == "" {
+		return true
+	}
+	for _, vpcProviderType := range vpcProviderTypes {
+		if providerType == vpcProviderType {
+			return true
+		}
+	}
+	logger.Info("Storage secret names a non-VPC provider type", zap.String("providerType", providerType))
+	return false
+}
+
+// getSecretProviderArgs returns the secret provider arguments selecting the credential set for the node.
+func getSecretProviderArgs(node *v1.Node, credentialKeys []string, logger *zap.Logger) map[string]string {
+	defaultArgs := map[string]string{
+		sp.ProviderType: sp.VPC,
+	}
+	if node == nil {
+		return defaultArgs
+	}
+	secretKey, ok := node.ObjectMeta.Annotations[credentialKeyAnnotationKey]
+	if !ok || secretKey == "" {
+		return defaultArgs
+	}
+	for _, key := range credentialKeys {
+		if key == secretKey {
+			logger.Info("Using credentials selected by node annotation", zap.String("secretKey", secretKey))
+			return map[string]string{
+				sp.SecretKey: secretKey,
+			}
+		}
+	}
+	logger.Warn("Node annotation selects credentials which are not configured, using default credentials", zap.String("secretKey", secretKey), zap.Strings("credentialKeys", credentialKeys))
+	return defaultArgs
+}
+
+// getRiaasInstanceURL builds the instance list URL from the RIAAS endpoint, which must carry a scheme and host.
+// A non-empty pathPrefix, which must start with "/", is placed before /v1/instances.
+// A non-zero pageLimit sets the number of instances requested per page.
+func getRiaasInstanceURL(riaasURL, pathPrefix string, pageLimit int) (*url.URL, error) {
+	endpointURL, err := url.Parse(riaasURL)
+	if err != nil {
+		return nil, &ErrInvalidRIAASURL{URL: riaasURL, Err: err}
+	}
+	if endpointURL.Scheme == "" || endpointURL.Host == "" {
+		return nil, &ErrInvalidRIAASURL{URL: riaasURL, Reason: "scheme and host must not be empty"}
+	}
+	if pathPrefix != "" && !strings.HasPrefix(pathPrefix, "/") {
+		return nil, &ErrInvalidRIAASURL{URL: riaasURL, Reason: fmt.Sprintf("path prefix %q must start with /", pathPrefix)}
+	}
+	riaasInstanceURL, err := url.Parse(fmt.Sprintf("%s%s/v1/instances?generation=%s&version=%s", riaasURL, strings.TrimSuffix(pathPrefix, "/"), vpcGeneration, vpcRiaasVersion))
+	if err != nil {
+		return nil, &ErrInvalidRIAASURL{URL: riaasURL, Err: err}
+	}
+	if pageLimit > 0 {
+		q := riaasInstanceURL.Query()
+		q.Set("limit", strconv.Itoa(pageLimit))
+		riaasInstanceURL.RawQuery = q.Encode()
+	}
+	return riaasInstanceURL, nil
+}
+
+// isRetryable reports whether a request that failed with err on the given attempt should be
+// retried, according to the attempt cap of the error class.
+func (cfg *Config) isRetryable(err error, attempt int) bool {
+	class := classifyError(err)
+	return class != "" && attempt < cfg.GetRetryAttempts(class)
+}
+
+// classifyError returns the retry class of err, or empty if it is not retryable.
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var statusErr *ErrRIAASStatus
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode == http.StatusTooManyRequests {
+			return errorClassRateLimit
+		}
+		if statusErr.StatusCode >= http.StatusInternalServerError {
+			return errorClassServer
+		}
+		return ""
+	}
+	if iam.IsConnectionError(err) {
+		return errorClassConnection
+	}
+	return ""
+}
+
+// parseRetryInterval parses the interval between retries, falling back to defaultRetryInterval if it
+// is invalid or not positive, as a zero interval would busy-loop.
+func parseRetryInterval(value string, logger *zap.Logger) time.Duration {
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		logger.Warn("Invalid retry interval, using default", zap.String("value", value), zap.Error(err), zap.Duration("default", defaultRetryInterval))
+		return defaultRetryInterval
+	}
+	return interval
+}
+
 // ErrorRetry ...
-func ErrorRetry(logger *zap.Logger, funcToRetry func() (error, bool)) error {
+func ErrorRetry(ctx context.Context, logger *zap.Logger, funcToRetry func() (error, bool)) error {
+	return errorRetry(ctx, logger, maxAttempts, defaultRetryInterval, funcToRetry)
+}
+
+// errorRetry calls funcToRetry until it succeeds or asks to stop, at most attempts times, sleeping
+// retryIntervaltime between the calls. It returns the context error once ctx is done.
+func errorRetry(ctx context.Context, logger *zap.Logger, attempts int, retryIntervaltime time.Duration, funcToRetry func() (error, bool)) error {
 	var err error
 	var shouldStop bool
-	retryIntervaltime, err := time.ParseDuration(retryInterval)
-	if err != nil {
-		logger.Warn("time.ParseDuration failed", zap.Error(err))
-	}
 	for i := 0; ; i++ {
 		err, shouldStop = funcToRetry()
 		logger.Debug("Retry Function Result", zap.Error(err), zap.Bool("shouldStop", shouldStop))
@@ -104,10 +439,17 @@ func ErrorRetry(logger *zap.Logger, funcToRetry func() (error, bool)) error {
 			return err
 		}
 		//Stop if out of retries
-		if i >= (maxAttempts - 1) {
+		if i >= (attempts - 1) {
 			break
 		}
-		time.Sleep(retryIntervaltime)
+		timer := time.NewTimer(retryIntervaltime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Warn("Giving up retrying as the context is done", zap.Error(err))
+			return ctx.Err()
+		case <-timer.C:
+		}
 		logger.Warn("retrying after Error:", zap.Error(err))
 	}
 	//error set by name above so no need to explicitly return it
@@ -116,8 +458,60 @@ func ErrorRetry(logger *zap.Logger, funcToRetry func() (error, bool)) error {
 
 // CheckIfRequiredLabelsPresent checks if nodes are already labeled with the required labels
 func CheckIfRequiredLabelsPresent(labelMap map[string]string) bool {
-	_, okvpcBlockLabelKey := labelMap[vpcBlockLabelKey]
-	_, okvpcInstanceID := labelMap[instanceIDLabelKey]
+	return checkRequiredLabels(labelMap, vpcBlockLabelKey, instanceIDLabelKey)
+}
+
+// CheckIfRequiredLabelsPresent checks if nodes are already labeled with the required labels,
+// using the configured label keys.
+func (cfg *Config) CheckIfRequiredLabelsPresent(labelMap map[string]string) bool {
+	if !checkRequiredLabels(labelMap, cfg.labelKey(vpcBlockLabelKey), cfg.GetInstanceIDLabelKey()) {
+		return false
+	}
+	if cfg.RequireVpcIDLabel {
+		_, ok := labelMap[cfg.labelKey(vpcIDLabelKey)]
+		return ok
+	}
+	return true
+}
+
+// NeedsRelabel reports whether the node must be labeled: either a required label is missing or,
+// if enabled, the node rebooted since the last update, which some setups wipe labels on.
+func (cfg *Config) NeedsRelabel(node *v1.Node) bool {
+	return !cfg.CheckIfRequiredLabelsPresent(node.ObjectMeta.Labels) || cfg.bootIDChanged(node)
+}
+
+// IsOptedIn reports whether the node is to be labeled: always, unless opt-in is enabled and the
+// node is not annotated with vpc-node-label-updater/enabled=true.
+func (cfg *Config) IsOptedIn(node *v1.Node) bool {
+	if !cfg.OptIn {
+		return true
+	}
+	return node != nil && node.ObjectMeta.Annotations[optInAnnotationKey] == "true"
+}
+
+// bootIDChanged reports whether relabeling on reboot is enabled and the node's boot ID differs
+// from the one recorded at the last update. Nodes which do not report a boot ID never change.
+func (cfg *Config) bootIDChanged(node *v1.Node) bool {
+	if !cfg.RelabelOnBootIDChange || node == nil || node.Status.NodeInfo.BootID == "" {
+		return false
+	}
+	return node.ObjectMeta.Annotations[bootIDAnnotationKey] != node.Status.NodeInfo.BootID
+}
+
+// VerifyNodeLabels reads the configured node and reports whether it carries all required labels.
+// It makes no changes and only needs get access to nodes.
+func VerifyNodeLabels(ctx context.Context, k8sClient kubernetes.Interface, cfg *Config) (bool, error) {
+	node, err := k8sClient.CoreV1().Nodes().Get(ctx, cfg.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return cfg.CheckIfRequiredLabelsPresent(node.ObjectMeta.Labels), nil
+}
+
+// checkRequiredLabels checks if the labels include the given vpc block and instance ID label keys.
+func checkRequiredLabels(labelMap map[string]string, vpcBlockKey, instanceIDKey string) bool {
+	_, okvpcBlockLabelKey := labelMap[vpcBlockKey]
+	_, okvpcInstanceID := labelMap[instanceIDKey]
 	/* For users using version <=4.2.2, need to check for both label vpcBlockLabelKey and instanceIDLabelKey
 	TODO: Keep only check for vpcBlockLabelKey when version 4.2.2 is removed
 	*/
@@ -127,6 +521,26 @@ func CheckIfRequiredLabelsPresent(labelMap map[string]string) bool {
 	return false
 }
 
+// ResolveNodeName returns the name of the node the updater runs on. NODE_NAME is used when set,
+// otherwise the spec.nodeName of the pod identified by POD_NAME and POD_NAMESPACE is read.
+func ResolveNodeName(ctx context.Context, k8sClient kubernetes.Interface, cfg *Config, logger *zap.Logger) (string, error) {
+	if cfg.NodeName != "" {
+		return cfg.NodeName, nil
+	}
+	if cfg.PodName == "" || cfg.PodNamespace == "" {
+		return "", errors.New("node name is not set, either NODE_NAME or POD_NAME and POD_NAMESPACE must be provided")
+	}
+	logger.Info("NODE_NAME is not set, resolving node name from pod", zap.String("podName", cfg.PodName), zap.String("podNamespace", cfg.PodNamespace))
+	pod, err := k8sClient.CoreV1().Pods(cfg.PodNamespace).Get(ctx, cfg.PodName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s/%s to resolve node name: %w", cfg.PodNamespace, cfg.PodName, err)
+	}
+	if pod.Spec.NodeName == "" {
+		return "", fmt.Errorf("pod %s/%s is not scheduled to a node yet", cfg.PodNamespace, cfg.PodName)
+	}
+	return pod.Spec.NodeName, nil
+}
+
 // getEndpointURL corrects endpoint url if it is of form "http://"
 func getEndpointURL(url string, logger *zap.Logger) string {
 	if strings.Contains(url, "http://") {
@@ -137,105 +551,534 @@ func getEndpointURL(url string, logger *zap.Logger) string {
 }
 
 // GetWorkerDetails ...
-func (c *VpcNodeLabelUpdater) GetWorkerDetails(workerNodeName string) (*NodeInfo, error) {
+func (c *VpcNodeLabelUpdater) GetWorkerDetails(ctx context.Context, workerNodeName string) (*NodeInfo, error) {
+	if instanceID := c.getInstanceIDHint(); instanceID != "" {
+		c.Logger.Info("Node carries an instance ID hint. Getting instance detail by ID from vpc provider", zap.String("instanceID", instanceID))
+		nodeinfo, err := c.GetInstanceByID(ctx, instanceID)
+		if err == nil {
+			return nodeinfo, nil
+		}
+		c.Logger.Warn("Failed to get instance by ID hint, falling back to lookup by node name", zap.String("instanceID", instanceID), zap.Error(err))
+	}
+	// The node is looked up by instance ID hint, then by name or IP, then by hostname. MATCH_HOSTNAME looks
+	// it up by hostname before name or IP instead.
+	hostnames := c.getHostnameCandidates(workerNodeName)
+	matchHostnameFirst := c.getConfig().MatchHostname
+	if matchHostnameFirst {
+		c.Logger.Info("Getting instance detail by hostname from vpc provider before name or IP", zap.Strings("hostnames", hostnames))
+		nodeinfo, err := c.GetInstanceByHostname(ctx, hostnames...)
+		if err == nil || !errors.Is(err, ErrInstanceNotFound) {
+			return nodeinfo, err
+		}
+		c.Logger.Info("Instance not found by hostname, falling back to lookup by name or IP", zap.Error(err))
+	}
+	zoneHint := c.getZoneHint()
+	var nodeinfo *NodeInfo
+	var err error
 	if net.ParseIP(workerNodeName) == nil {
 		c.Logger.Info("Worker Node Name is not in ip format. Getting instance detail by name from vpc provider")
-		return c.GetInstanceByName(workerNodeName)
+		nodeinfo, err = c.GetInstanceByName(ctx, workerNodeName, zoneHint)
+	} else {
+		c.Logger.Info("Worker Node Name is in ip format. Getting instance detail by ipv4 from vpc provider")
+		nodeinfo, err = c.GetInstanceByIP(ctx, workerNodeName, zoneHint)
 	}
-	c.Logger.Info("Worker Node Name is in ip format. Getting instance detail by ipv4 from vpc provider")
-	return c.GetInstanceByIP(workerNodeName)
+	if err == nil || matchHostnameFirst || !errors.Is(err, ErrInstanceNotFound) {
+		return nodeinfo, err
+	}
+	c.Logger.Info("Instance not found by name or IP, getting instance detail by hostname from vpc provider", zap.Strings("hostnames", hostnames), zap.Error(err))
+	return c.GetInstanceByHostname(ctx, hostnames...)
 }
 
-// GetInstancesFromVPC ...
-func (c *VpcNodeLabelUpdater) GetInstancesFromVPC(riaasInstanceURL *url.URL) ([]*Instance, error) {
-	c.Logger.Info("Getting instance List from VPC provider")
+// getHostnameCandidates returns the hostnames to match the instance by, in order: the worker node name,
+// then the node's kubernetes.io/hostname label if it differs.
+func (c *VpcNodeLabelUpdater) getHostnameCandidates(workerNodeName string) []string {
+	hostnames := []string{workerNodeName}
+	if c.Node != nil {
+		if hostname := c.Node.ObjectMeta.Labels[v1.LabelHostname]; hostname != "" && hostname != workerNodeName {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
 
-	instanceReq := &http.Request{
+// getZoneHint returns the zone the node is already labeled with, if any.
+func (c *VpcNodeLabelUpdater) getZoneHint() string {
+	if c.Node == nil {
+		return ""
+	}
+	cfg := c.getConfig()
+	if zone := c.Node.ObjectMeta.Labels[cfg.labelKey(topologyZoneLabelKey)]; zone != "" {
+		return zone
+	}
+	return c.Node.ObjectMeta.Labels[cfg.labelKey(failureZoneLabelKey)]
+}
+
+// selectInstance returns the candidate in the hinted zone, or the first candidate if none is.
+func selectInstance(candidates []*Instance, zoneHint string) *Instance {
+	if zoneHint != "" {
+		for _, candidate := range candidates {
+			if candidate.Zone != nil && candidate.Zone.Name == zoneHint {
+				return candidate
+			}
+		}
+	}
+	return candidates[0]
+}
+
+// parseProviderID returns the instance ID encoded as the last segment of an IBM Cloud provider ID
+// such as "ibm://account-id///cluster-id/instance-id", and whether one was found.
+func parseProviderID(providerID string) (string, bool) {
+	if !strings.HasPrefix(providerID, providerIDPrefix) {
+		return "", false
+	}
+	instanceID := providerID[strings.LastIndex(providerID, "/")+1:]
+	if instanceID == "" || len(instanceID) == len(providerID)-len(providerIDPrefix) {
+		return "", false
+	}
+	return instanceID, true
+}
+
+// getInstanceIDHint returns the instance ID annotated on the node, or else the one encoded in its
+// provider ID, if any.
+func (c *VpcNodeLabelUpdater) getInstanceIDHint() string {
+	if c.Node == nil {
+		return ""
+	}
+	if instanceID := c.Node.ObjectMeta.Annotations[instanceIDAnnotationKey]; instanceID != "" {
+		return instanceID
+	}
+	instanceID, _ := parseProviderID(c.Node.Spec.ProviderID)
+	return instanceID
+}
+
+// authorizationHeader returns the Authorization header value for the token with the given scheme, replacing
+// any scheme the token already carries so that the configured casing is the one sent.
+func authorizationHeader(scheme, token string) string {
+	if i := strings.IndexByte(token, ' '); i > 0 && strings.EqualFold(token[:i], defaultAuthScheme) {
+		token = strings.TrimSpace(token[i+1:])
+	}
+	return scheme + " " + token
+}
+
+// refreshIAMAccessToken replaces the IAM access token with a freshly fetched one.
+func (c *VpcNodeLabelUpdater) refreshIAMAccessToken() error {
+	c.Logger.Warn("RIAAS rejected the IAM access token, refreshing it")
+	accessToken, err := c.StorageSecretConfig.RefreshIAMAccessToken()
+	if err != nil {
+		c.Logger.Error("Failed to refresh IAM access token", zap.Error(err))
+		return err
+	}
+	c.StorageSecretConfig.IAMAccessToken = accessToken
+	return nil
+}
+
+// getFromVPC performs an authenticated GET against riaasURL, retrying retryable errors, and returns the
+// response body for the caller to decode and close.
+func (c *VpcNodeLabelUpdater) getFromVPC(ctx context.Context, riaasURL *url.URL) (io.ReadCloser, error) {
+	req := (&http.Request{
 		Method: "GET",
-		URL:    riaasInstanceURL,
+		URL:    riaasURL,
 		Header: map[string][]string{
 			"Content-Type":  {"application/json"},
 			"Accept":        {"application/json"},
-			"Authorization": {c.StorageSecretConfig.IAMAccessToken},
+			"Authorization": {authorizationHeader(c.getConfig().GetAuthScheme(), c.StorageSecretConfig.IAMAccessToken)},
 		},
-	}
-	var instanceResponse *http.Response
+	}).WithContext(ctx)
+	var resp *http.Response
 	var err error
 
-	err = ErrorRetry(c.Logger, func() (error, bool) {
-		instanceResponse, err = http.DefaultClient.Do(instanceReq) //nolint
-		return err, !iam.IsConnectionError(err)                    // Skip retry if its not connection error
+	attempt := 0
+	refreshed := false
+	err = c.getConfig().ErrorRetry(ctx, c.Logger, func() (error, bool) {
+		attempt++
+		if attempt > 1 {
+			atomic.AddInt64(&metrics.riaasRetries, 1)
+		}
+		atomic.AddInt64(&c.riaasAttempts, 1)
+		resp, err = c.doRIAASRequest(req)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && !refreshed && c.StorageSecretConfig.RefreshIAMAccessToken != nil {
+			// The token may have expired while retrying, refresh it and repeat the request once.
+			refreshed = true
+			resp.Body.Close()
+			if refreshErr := c.refreshIAMAccessToken(); refreshErr != nil {
+				return fmt.Errorf("%w: %v", ErrIAMToken, refreshErr), true
+			}
+			req.Header.Set("Authorization", authorizationHeader(c.getConfig().GetAuthScheme(), c.StorageSecretConfig.IAMAccessToken))
+			atomic.AddInt64(&c.riaasAttempts, 1)
+			resp, err = c.doRIAASRequest(req)
+		}
+		if err == nil {
+			atomic.StoreInt64(&c.lastRIAASStatus, int64(resp.StatusCode))
+		}
+		if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden ||
+			resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError) {
+			resp.Body.Close()
+			err = &ErrRIAASStatus{StatusCode: resp.StatusCode}
+		}
+		return err, !c.getConfig().isRetryable(err, attempt) // Skip retry once the error class is out of attempts
 	})
 
 	if err != nil {
 		return nil, err
 	}
-	defer instanceResponse.Body.Close()
-	// read response body
-	instance, err := io.ReadAll(instanceResponse.Body)
+	return resp.Body, nil
+}
+
+// doRIAASRequest sends req to RIAAS, recording its duration.
+func (c *VpcNodeLabelUpdater) doRIAASRequest(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	defer func() { metrics.riaasDuration.observe(time.Since(start)) }()
+	return c.getHTTPClient().Do(req) //nolint
+}
+
+// GetInstancesFromVPC returns the instances listed at riaasInstanceURL, following the next page
+// links until the last page.
+func (c *VpcNodeLabelUpdater) GetInstancesFromVPC(ctx context.Context, riaasInstanceURL *url.URL) ([]*Instance, error) {
+	if path := c.getConfig().InstanceListFile; path != "" {
+		c.Logger.Warn("Getting instance list from file instead of VPC provider", zap.String("instanceListFile", path))
+		return readInstanceListFile(path, riaasInstanceURL.Query().Get("name"))
+	}
+	c.Logger.Info("Getting instance List from VPC provider")
+
+	var instances []*Instance
+	visited := map[string]bool{}
+	for pageURL := riaasInstanceURL; pageURL != nil; {
+		visited[pageURL.String()] = true
+		page, next, err := c.getInstancePage(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, page...)
+		if next == "" {
+			break
+		}
+		if pageURL, err = pageURL.Parse(next); err != nil {
+			return nil, fmt.Errorf("failed to parse next page link %q of instances: %w", next, err)
+		}
+		if pageURL.Host != riaasInstanceURL.Host && c.getConfig().RedirectAuthPolicy != RedirectAuthPreserve {
+			return nil, fmt.Errorf("refusing next page link %q of instances to another host", next)
+		}
+		if visited[pageURL.String()] {
+			return nil, fmt.Errorf("next page link %q of instances repeats an earlier page", next)
+		}
+		c.Logger.Info("Getting next page of instance List from VPC provider", zap.Int("instancesSoFar", len(instances)))
+	}
+	if len(instances) == 0 {
+		return nil, emptyInstanceListError(instanceListFilters(riaasInstanceURL.Query()))
+	}
+	return instances, nil
+}
+
+// instanceFilterParams are the instance list query parameters that narrow the list server-side.
+var instanceFilterParams = []string{"name", "vpc.id", "vpc.crn", "vpc.name", "resource_group.id", "placement_group.id", "dedicated_host.id", "dedicated_host.name"}
+
+// instanceListFilters returns the filters set in query as "key=value" pairs.
+func instanceListFilters(query url.Values) []string {
+	var filters []string
+	for _, param := range instanceFilterParams {
+		if value := query.Get(param); value != "" {
+			filters = append(filters, param+"="+value)
+		}
+	}
+	return filters
+}
+
+// emptyInstanceListError returns the error for an empty instance list: ErrInstanceNotFound if filters
+// narrowed the list, or ErrEmptyInstanceList if the whole list is empty.
+func emptyInstanceListError(filters []string) error {
+	if len(filters) > 0 {
+		return fmt.Errorf("failed to get worker details as instance list is empty for filter %s: %w", strings.Join(filters, "&"), ErrInstanceNotFound)
+	}
+	return fmt.Errorf("failed to get worker details as instance list is empty without a filter, check the credentials and the account: %w", ErrEmptyInstanceList)
+}
+
+// readInstanceListFile reads a captured instance list response from path, keeping only the
+// instances called name when it is set, like the RIAAS name filter.
+func readInstanceListFile(path, name string) ([]*Instance, error) {
+	data, err := os.ReadFile(path) // #nosec G304: the path is set by the operator for offline debugging.
 	if err != nil {
-		c.Logger.Error("Failed to read response body of instance details from riaas provider", zap.Error(err))
-		return nil, err
+		return nil, fmt.Errorf("failed to read instance list file: %w", err)
 	}
 	var instanceList InstanceList
-	err = json.Unmarshal(instance, &instanceList)
+	if err = json.Unmarshal(data, &instanceList); err != nil {
+		return nil, fmt.Errorf("failed to parse instance list file %s: %w", path, err)
+	}
+	var instances []*Instance
+	for _, instance := range instanceList.Instances {
+		if instance != nil && (name == "" || instance.Name == name) {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		var filters []string
+		if name != "" {
+			filters = append(filters, "name="+name)
+		}
+		return nil, emptyInstanceListError(filters)
+	}
+	return instances, nil
+}
+
+// getInstancePage fetches a single page of the instance list, returning its instances and the
+// link to the next page, empty on the last page.
+func (c *VpcNodeLabelUpdater) getInstancePage(ctx context.Context, pageURL *url.URL) ([]*Instance, string, error) {
+	body, err := c.getFromVPC(ctx, pageURL)
 	if err != nil {
-		return nil, errors.New("failed to unmarshal json response of instances")
+		return nil, "", err
 	}
-	if len(instanceList.Instances) == 0 {
-		return nil, errors.New("failed to get worker details as instance list is empty")
+	defer body.Close()
+	instances, next, err := decodeInstanceList(body)
+	if err != nil {
+		return nil, "", errors.New("failed to unmarshal json response of instances")
 	}
-	return instanceList.Instances, nil
+	return instances, next, nil
 }
 
-// GetInstanceByIP ...
-func (c *VpcNodeLabelUpdater) GetInstanceByIP(workerNodeName string) (*NodeInfo, error) {
+// decodeInstanceList streams an instance list response into instances carrying only the
+// fields used for matching, which keeps allocations low on accounts with many instances.
+// It also returns the link to the next page, empty on the last page.
+func decodeInstanceList(r io.Reader) ([]*Instance, string, error) {
+	var summaries instanceSummaryList
+	if err := json.NewDecoder(r).Decode(&summaries); err != nil {
+		return nil, "", err
+	}
+	instances := make([]*Instance, len(summaries.Instances))
+	for i := range summaries.Instances {
+		instances[i] = summaries.Instances[i].toInstance()
+	}
+	var next string
+	if summaries.Next != nil {
+		next = summaries.Next.Href
+	}
+	return instances, next, nil
+}
+
+// GetInstanceByID fetches a single instance from /v1/instances/{id}.
+func (c *VpcNodeLabelUpdater) GetInstanceByID(ctx context.Context, instanceID string) (*NodeInfo, error) {
+	c.Logger.Info("Getting instance from VPC provider by ID", zap.String("instanceID", instanceID))
+	if path := c.getConfig().InstanceListFile; path != "" {
+		instances, err := readInstanceListFile(path, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			if instance.ID == instanceID {
+				return c.getNodeInfo(instance), nil
+			}
+		}
+		return nil, fmt.Errorf("failed to get worker details, instance with id %s was not found in instance list file %s: %w", instanceID, path, ErrInstanceNotFound)
+	}
+
+	riaasInstanceURL := *c.StorageSecretConfig.RiaasEndpointURL
+	riaasInstanceURL.Path = strings.TrimSuffix(riaasInstanceURL.Path, "/") + "/" + url.PathEscape(instanceID)
+	q := riaasInstanceURL.Query()
+	q.Del("name")
+	riaasInstanceURL.RawQuery = q.Encode()
+
+	body, err := c.getFromVPC(ctx, &riaasInstanceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var instance Instance
+	if err = json.NewDecoder(body).Decode(&instance); err != nil {
+		return nil, errors.New("failed to unmarshal json response of instance")
+	}
+	if instance.ID != instanceID {
+		return nil, fmt.Errorf("failed to get worker details, instance with id %s was not found in vpc provider: %w", instanceID, ErrInstanceNotFound)
+	}
+	c.Logger.Info("Successfully found instance", zap.Reflect("instanceDetail", instance))
+	return c.getNodeInfo(&instance), nil
+}
+
+// GetInstanceByIP returns the instance whose primary IP matches the worker node name. When several
+// instances match, the one in the hinted zone is preferred.
+func (c *VpcNodeLabelUpdater) GetInstanceByIP(ctx context.Context, workerNodeName, zoneHint string) (*NodeInfo, error) {
 	c.Logger.Info("Getting InstanceList from VPC provider...")
 
-	instanceList, err := c.GetInstancesFromVPC(c.StorageSecretConfig.RiaasEndpointURL)
+	instanceList, err := c.GetInstancesFromVPC(ctx, c.StorageSecretConfig.RiaasEndpointURL)
 	if err != nil {
 		return nil, err
 	}
 
+	var candidates []*Instance
 	for _, instanceItem := range instanceList {
 		// Check if worker IP is matching with requested worker node name
-		if instanceItem.PrimaryNetworkInterface.PrimaryIpv4Address == workerNodeName {
-			c.Logger.Info("Successfully found instance", zap.Reflect("instanceDetail", instanceItem))
-			return c.getNodeInfo(instanceItem), nil
+		if instanceItem.PrimaryNetworkInterface != nil && instanceItem.PrimaryNetworkInterface.PrimaryIpv4Address == workerNodeName {
+			candidates = append(candidates, instanceItem)
+		}
+	}
+	if len(candidates) == 0 {
+		// Kubelet may report the IP of a secondary interface as the node name.
+		for _, instanceItem := range instanceList {
+			if hasNetworkInterfaceIP(instanceItem, workerNodeName) {
+				candidates = append(candidates, instanceItem)
+			}
+		}
+		if len(candidates) > 0 {
+			c.Logger.Info("Worker IP matches a secondary network interface", zap.String("ip", workerNodeName))
+		}
+	}
+	if len(candidates) == 0 {
+		instance, err := c.matchInstanceBySubnet(workerNodeName, instanceList)
+		if err != nil {
+			return nil, err
+		}
+		if instance == nil {
+			return nil, fmt.Errorf("failed to get worker details, worker with name %s was not found in the instanceList fetched from vpc provider: %w", workerNodeName, ErrInstanceNotFound)
+		}
+		return c.getNodeInfo(instance), nil
+	}
+	instance := selectInstance(candidates, zoneHint)
+	c.Logger.Info("Successfully found instance", zap.Reflect("instanceDetail", instance))
+	return c.getNodeInfo(instance), nil
+}
+
+// hasNetworkInterfaceIP reports whether any of the instance's network interfaces, which include the
+// primary one, has ip.
+func hasNetworkInterfaceIP(instance *Instance, ip string) bool {
+	if instance.NetworkInterfaces == nil {
+		return false
+	}
+	for _, networkInterface := range *instance.NetworkInterfaces {
+		if networkInterface.PrimaryIpv4Address == ip {
+			return true
 		}
 	}
-	err = fmt.Errorf("failed to get worker details, worker with name %s was not found in the instanceList fetched from vpc provider", workerNodeName)
-	return nil, err
+	return false
 }
 
-// GetInstanceByName ...
-func (c *VpcNodeLabelUpdater) GetInstanceByName(workerNodeName string) (*NodeInfo, error) {
+// matchInstanceBySubnet returns the instance whose primary IP is in the same configured IP match
+// subnet as the node IP, or nil if the node IP is in none of them or no instance is. Several
+// instances in the subnet are an error, as picking one could label the node with another's details.
+func (c *VpcNodeLabelUpdater) matchInstanceBySubnet(nodeIP string, instanceList []*Instance) (*Instance, error) {
+	networks, err := c.getConfig().getIPMatchNetworks()
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(nodeIP)
+	for _, network := range networks {
+		if !network.Contains(ip) {
+			continue
+		}
+		var matches []*Instance
+		for _, instanceItem := range instanceList {
+			if instanceItem.PrimaryNetworkInterface == nil {
+				continue
+			}
+			if network.Contains(net.ParseIP(instanceItem.PrimaryNetworkInterface.PrimaryIpv4Address)) {
+				matches = append(matches, instanceItem)
+			}
+		}
+		if len(matches) > 1 {
+			return nil, fmt.Errorf("failed to get worker details, %d instances have a primary IP in subnet %s of worker %s", len(matches), network, nodeIP)
+		}
+		if len(matches) == 1 {
+			c.Logger.Info("Matched instance by subnet", zap.String("nodeIP", nodeIP), zap.String("subnet", network.String()), zap.Reflect("instanceDetail", matches[0]))
+			return matches[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetInstanceByHostname lists the instances and returns the first one whose hostname, or else whose
+// primary network interface name, matches one of the hostnames, tried in order.
+func (c *VpcNodeLabelUpdater) GetInstanceByHostname(ctx context.Context, hostnames ...string) (*NodeInfo, error) {
+	c.Logger.Info("Getting InstanceList from VPC provider...")
+
+	instanceList, err := c.GetInstancesFromVPC(ctx, c.StorageSecretConfig.RiaasEndpointURL)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hostname := range hostnames {
+		if hostname == "" {
+			continue
+		}
+		for _, instanceItem := range instanceList {
+			if instanceItem.Hostname == hostname {
+				c.Logger.Info("Successfully found instance by hostname", zap.String("hostname", hostname), zap.Reflect("instanceDetail", instanceItem))
+				return c.getNodeInfo(instanceItem), nil
+			}
+		}
+		for _, instanceItem := range instanceList {
+			if instanceItem.PrimaryNetworkInterface != nil && instanceItem.PrimaryNetworkInterface.Name == hostname {
+				c.Logger.Info("Successfully found instance by primary network interface name", zap.String("hostname", hostname), zap.Reflect("instanceDetail", instanceItem))
+				return c.getNodeInfo(instanceItem), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("failed to get worker details, worker with hostname %s was not found in the instanceList fetched from vpc provider: %w", strings.Join(hostnames, " or "), ErrInstanceNotFound)
+}
+
+// GetInstanceByName returns the instance named after the worker node. When several instances
+// share the name, the one in the hinted zone is preferred.
+func (c *VpcNodeLabelUpdater) GetInstanceByName(ctx context.Context, workerNodeName, zoneHint string) (*NodeInfo, error) {
 	c.Logger.Info("Getting InstanceList from VPC provider...")
 
-	riaasInstanceURL := c.StorageSecretConfig.RiaasEndpointURL
+	// Work on a copy so the configured endpoint is not modified by concurrent lookups.
+	riaasInstanceURL := *c.StorageSecretConfig.RiaasEndpointURL
 	q := riaasInstanceURL.Query()
-	q.Add("name", workerNodeName)
-	c.StorageSecretConfig.RiaasEndpointURL.RawQuery = q.Encode()
+	q.Set("name", workerNodeName)
+	riaasInstanceURL.RawQuery = q.Encode()
 
-	instanceList, err := c.GetInstancesFromVPC(riaasInstanceURL)
+	instanceList, err := c.GetInstancesFromVPC(ctx, &riaasInstanceURL)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.getNodeInfo(instanceList[0]), nil
+	// Only trust instances which carry the queried name, should the provider ignore the filter.
+	var candidates []*Instance
+	var names []string
+	for _, instance := range instanceList {
+		if instance.Name == workerNodeName {
+			candidates = append(candidates, instance)
+		} else {
+			names = append(names, instance.Name)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("failed to get worker details, vpc provider returned no instance named %s, only %v: %w", workerNodeName, names, ErrInstanceNotFound)
+	}
+	return c.getNodeInfo(selectInstance(candidates, zoneHint)), nil
 }
 
 func (c *VpcNodeLabelUpdater) getNodeInfo(instance *Instance) *NodeInfo {
 	insID := instance.ID
-	zone := instance.Zone.Name
-	lastInd := strings.LastIndex(zone, "-")
-	region := zone[:lastInd]
+	var zone string
+	if instance.Zone != nil {
+		zone = instance.Zone.Name
+	}
+	region := c.getConfig().GetRegionFromZone(zone)
+	if zone != "" && region == zone {
+		c.Logger.Warn("Zone name has no hyphenated suffix, using the whole zone as region", zap.String("zone", zone))
+	}
 
 	nodeDetails := &NodeInfo{
 		InstanceID: insID,
 		Zone:       zone,
 		Region:     region,
+		Status:     instance.Status,
+	}
+	if instance.Profile != nil {
+		nodeDetails.Profile = instance.Profile.Name
+	}
+	if instance.Bandwidth != nil {
+		nodeDetails.Bandwidth = *instance.Bandwidth
+	}
+	if instance.Vpc != nil {
+		nodeDetails.VpcID = instance.Vpc.ID
 	}
 	c.Logger.Info("Successfully fetched node detail from VPC provider", zap.Reflect("nodeDetails", nodeDetails))
 	return nodeDetails
 }
+
+// getRegionFromZone derives the region from a zone name such as "us-south-1". A zone without a
+// hyphen before its suffix is returned whole.
+func getRegionFromZone(zone string) string {
+	lastInd := strings.LastIndex(zone, "-")
+	if lastInd <= 0 {
+		return zone
+	}
+	return zone[:lastInd]
+}
@@ -18,17 +18,34 @@
 package nodeupdater
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	errors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/IBM/ibmcloud-volume-interface/lib/provider"
+	sp "github.com/IBM/secret-common-lib/pkg/secret_provider"
 	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	secretprovider "github.com/IBM/secret-utils-lib/pkg/secret_provider"
+	secretutils "github.com/IBM/secret-utils-lib/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func initNodeLabelUpdater(t *testing.T) *VpcNodeLabelUpdater {
@@ -74,6 +91,48 @@ func TestReadSecretConfiguration(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestIsVPCInfrastructure(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+
+	pwd, _ := os.Getwd()
+	providerTypeFile := filepath.Join(t.TempDir(), "slclient.toml")
+	assert.Nil(t, os.WriteFile(providerTypeFile, []byte("[vpc]\n  provider_type = \"softlayer\"\n"), 0600))
+	testCases := []struct {
+		name   string
+		file   string
+		expVPC bool
+	}{
+		{
+			name:   "no storage secret",
+			expVPC: true,
+		},
+		{
+			name:   "VPC storage secret",
+			file:   filepath.Join(pwd, "..", "..", "test-fixtures", "slclient.toml"),
+			expVPC: true,
+		},
+		{
+			name:   "classic storage secret",
+			file:   filepath.Join(pwd, "..", "..", "test-fixtures", "classic-slclient.toml"),
+			expVPC: false,
+		},
+		{
+			name:   "non-VPC provider type",
+			file:   providerTypeFile,
+			expVPC: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+		if tc.file != "" {
+			assert.Nil(t, k8s_utils.FakeCreateSecret(k8sClient, "DEFAULT", tc.file))
+		}
+		assert.Equal(t, tc.expVPC, IsVPCInfrastructure(&k8sClient, logger))
+	}
+}
+
 func TestCheckIfRequiredLabelsPresent(t *testing.T) {
 	labelMap := make(map[string]string)
 	exp := CheckIfRequiredLabelsPresent(labelMap)
@@ -82,6 +141,13 @@ func TestCheckIfRequiredLabelsPresent(t *testing.T) {
 	labelMap[instanceIDLabelKey] = "true"
 	ex := CheckIfRequiredLabelsPresent(labelMap)
 	assert.Equal(t, ex, true)
+
+	// The VPC ID label is only required when enabled.
+	assert.True(t, (&Config{}).CheckIfRequiredLabelsPresent(labelMap))
+	cfg := &Config{RequireVpcIDLabel: true}
+	assert.False(t, cfg.CheckIfRequiredLabelsPresent(labelMap))
+	labelMap[vpcIDLabelKey] = "r006-vpc-1"
+	assert.True(t, cfg.CheckIfRequiredLabelsPresent(labelMap))
 }
 
 func TestGetInstancesFromVPC(t *testing.T) {
@@ -131,7 +197,7 @@ func TestGetInstancesFromVPC(t *testing.T) {
 			assert.Nil(t, err)
 		} else {
 			updater.StorageSecretConfig.IAMAccessToken = tc.accessToken
-			_, err := updater.GetInstancesFromVPC(riaasInsURL)
+			_, err := updater.GetInstancesFromVPC(context.TODO(), riaasInsURL)
 			if err != nil {
 				if err.Error() != tc.expErr.Error() && !strings.Contains(err.Error(), tc.expErr.Error()) {
 					t.Fatalf("Expected error : %v, got: %v. err : %v", tc.expErr, err, err)
@@ -142,6 +208,96 @@ func TestGetInstancesFromVPC(t *testing.T) {
 	}
 }
 
+// newLargeInstanceList returns the JSON of an instance list with count fully populated instances.
+func newLargeInstanceList(t testing.TB, count int) []byte {
+	created := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	instanceList := InstanceList{Limit: count, TotalCount: count}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("worker-%d", i)
+		instance := newTestInstance(name, "instance-id-"+name, "us-south-1", fmt.Sprintf("10.%d.%d.%d", i/65536%256, i/256%256, i%256))
+		instance.Href = "https://us-south.iaas.cloud.ibm.com/v1/instances/" + instance.ID
+		instance.CRN = "crn:v1:bluemix:public:is:us-south-1:a/account::instance:" + instance.ID
+		instance.Hostname = name + ".example.com"
+		instance.Memory = 16
+		instance.CreatedAt = &created
+		instance.ResourceGroup = &ResourceGroup{ID: "resource-group-id", Name: "default"}
+		instance.Vcpu = &Vcpu{Architecture: "amd64", Count: 4}
+		instance.Vpc = &Vpc{ID: "vpc-id", Name: "vpc", CRN: "crn:v1:bluemix:public:is:us-south:a/account::vpc:vpc-id"}
+		instance.Profile = &Profile{Name: "bx2-4x16"}
+		instance.Image = &Image{ID: "image-id", Name: "ibm-ubuntu-20-04"}
+		instance.PrimaryNetworkInterface.ID = "nic-" + instance.ID
+		instance.PrimaryNetworkInterface.Subnet = &Subnet{ID: "subnet-id", Name: "subnet"}
+		instance.NetworkInterfaces = &[]NetworkInterface{*instance.PrimaryNetworkInterface}
+		instance.VolumeAttachments = &[]VolumeAttachment{{ID: "attachment-id", Volume: &Volume{ID: "volume-id", Name: "boot"}}}
+		instanceList.Instances = append(instanceList.Instances, instance)
+	}
+	body, err := json.Marshal(&instanceList)
+	if err != nil {
+		t.Fatalf("failed to marshal instance list: %v", err)
+	}
+	return body
+}
+
+func TestDecodeInstanceList(t *testing.T) {
+	body := newLargeInstanceList(t, 10)
+	var full InstanceList
+	assert.Nil(t, json.Unmarshal(body, &full))
+
+	instances, next, err := decodeInstanceList(bytes.NewReader(body))
+	assert.Nil(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, len(full.Instances), len(instances))
+	for i, instance := range instances {
+		assert.Equal(t, full.Instances[i].ID, instance.ID)
+		assert.Equal(t, full.Instances[i].Name, instance.Name)
+		assert.Equal(t, full.Instances[i].Hostname, instance.Hostname)
+		assert.Equal(t, full.Instances[i].CRN, instance.CRN)
+		assert.Equal(t, full.Instances[i].Zone.Name, instance.Zone.Name)
+		assert.Equal(t, full.Instances[i].PrimaryNetworkInterface.PrimaryIpv4Address, instance.PrimaryNetworkInterface.PrimaryIpv4Address)
+		assert.Equal(t, len(*full.Instances[i].NetworkInterfaces), len(*instance.NetworkInterfaces))
+	}
+
+	_, next, err = decodeInstanceList(strings.NewReader(`{"instances": [], "next": {"href": "https://riaas.example.com/v1/instances?start=abc"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "https://riaas.example.com/v1/instances?start=abc", next)
+
+	_, _, err = decodeInstanceList(strings.NewReader("not json"))
+	assert.NotNil(t, err)
+}
+
+// BenchmarkDecodeInstanceList compares the previous read and full unmarshal of an instance list
+// with the streaming decode into the summary target. With 5000 instances:
+//
+//	BenchmarkDecodeInstanceList/unmarshal-full    50    75138459 ns/op    17613573 B/op    127026 allocs/op
+//	BenchmarkDecodeInstanceList/stream-summary    50    56525328 ns/op    21140254 B/op     50202 allocs/op
+//
+// The decoder buffers the whole list as it is a single JSON value, so bytes allocated stay
+// comparable while allocations drop by 60% and decoding time by about 25%.
+func BenchmarkDecodeInstanceList(b *testing.B) {
+	body := newLargeInstanceList(b, 5000)
+	b.Run("unmarshal-full", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := io.ReadAll(bytes.NewReader(body))
+			if err != nil {
+				b.Fatal(err)
+			}
+			var instanceList InstanceList
+			if err := json.Unmarshal(data, &instanceList); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("stream-summary", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := decodeInstanceList(bytes.NewReader(body)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func TestGetInstanceByIP(t *testing.T) {
 	testCases := []struct {
 		name             string
@@ -258,6 +414,111 @@ func TestGetInstanceByName(t *testing.T) {
 	}
 }
 
+func TestGetInstanceByNameMismatch(t *testing.T) {
+	// The server ignores the name filter and returns every instance.
+	instances := []*Instance{
+		newTestInstance("other-worker", "instance-id-1", "us-south-1", "10.0.0.1"),
+		newTestInstance("valid-worker", "instance-id-2", "us-south-1", "10.0.0.2"),
+	}
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&InstanceList{Instances: instances})
+	}))
+	defer riaas.Close()
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	nodeinfo, err := updater.GetInstanceByName(context.TODO(), "valid-worker", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "instance-id-2", nodeinfo.InstanceID)
+
+	_, err = updater.GetInstanceByName(context.TODO(), "missing-worker", "")
+	assert.True(t, errors.Is(err, ErrInstanceNotFound))
+	assert.Contains(t, err.Error(), "no instance named missing-worker, only [other-worker valid-worker]")
+}
+
+func TestGetInstancesFromVPCEmptyList(t *testing.T) {
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&InstanceList{Instances: []*Instance{}})
+	}))
+	defer riaas.Close()
+
+	testCases := []struct {
+		name      string
+		query     string
+		expErr    error
+		expFilter string
+	}{
+		{
+			name:      "filtered by name",
+			query:     "?name=missing-worker",
+			expErr:    ErrInstanceNotFound,
+			expFilter: "name=missing-worker",
+		},
+		{
+			name:      "filtered by vpc",
+			query:     "?vpc.id=vpc-1&limit=50",
+			expErr:    ErrInstanceNotFound,
+			expFilter: "vpc.id=vpc-1",
+		},
+		{
+			name:   "unfiltered",
+			query:  "?limit=50",
+			expErr: ErrEmptyInstanceList,
+		},
+	}
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		riaasURL, _ := url.Parse(riaas.URL + "/v1/instances" + tc.query)
+		_, err := updater.GetInstancesFromVPC(context.TODO(), riaasURL)
+		assert.True(t, errors.Is(err, tc.expErr), err)
+		if tc.expFilter != "" {
+			assert.Contains(t, err.Error(), tc.expFilter)
+		}
+	}
+}
+
+func TestGetInstanceByIPSubnetMatch(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.240.0.4"),
+		newTestInstance("worker-2", "instance-id-2", "us-south-1", "10.240.64.4"),
+		newTestInstance("worker-3", "instance-id-3", "us-south-1", "10.240.64.5"),
+	})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name          string
+		nodeIP        string
+		cidrs         []string
+		expInstanceID string
+		expErr        string
+	}{
+		{name: "exact match", nodeIP: "10.240.0.4", cidrs: []string{"10.240.0.0/24"}, expInstanceID: "instance-id-1"},
+		{name: "exact match without subnets", nodeIP: "10.240.64.5", expInstanceID: "instance-id-3"},
+		{name: "no match without subnets", nodeIP: "10.240.0.9", expErr: "instance not found"},
+		{name: "subnet match", nodeIP: "10.240.0.9", cidrs: []string{"10.240.64.0/24", "10.240.0.0/24"}, expInstanceID: "instance-id-1"},
+		{name: "node IP outside the subnets", nodeIP: "10.241.0.9", cidrs: []string{"10.240.0.0/24"}, expErr: "instance not found"},
+		{name: "no instance in the subnet", nodeIP: "10.240.128.9", cidrs: []string{"10.240.128.0/24"}, expErr: "instance not found"},
+		{name: "several instances in the subnet", nodeIP: "10.240.64.9", cidrs: []string{"10.240.64.0/24"}, expErr: "2 instances have a primary IP in subnet 10.240.64.0/24"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, _ := initFakeNodeLabelUpdater(t, newTestNode(tc.nodeIP, map[string]string{}), riaas.URL)
+		updater.Config = &Config{IPMatchCIDRs: tc.cidrs}
+		nodeinfo, err := updater.GetInstanceByIP(context.TODO(), tc.nodeIP, "")
+		if tc.expErr != "" {
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.expErr)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expInstanceID, nodeinfo.InstanceID)
+	}
+
+	err := (&Config{NodeName: "valid-worker", IPMatchCIDRs: []string{"10.240.0.0"}}).Validate()
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
+
 func TestGetWorkerDetails(t *testing.T) {
 	testCases := []struct {
 		name             string
@@ -333,6 +594,31 @@ func TestGetNodeInfo(t *testing.T) {
 			instance: &Instance{ID: "instance-id", Zone: &Zone{Name: "xyz-1"}},
 			expRes:   &NodeInfo{InstanceID: "instance-id", Region: "xyz", Zone: "xyz-1"},
 		},
+		{
+			name:     "instance with profile and bandwidth",
+			instance: &Instance{ID: "instance-id", Zone: &Zone{Name: "xyz-1"}, Profile: &Profile{Name: "bx2-4x16"}, Bandwidth: func() *int64 { b := int64(4000); return &b }()},
+			expRes:   &NodeInfo{InstanceID: "instance-id", Region: "xyz", Zone: "xyz-1", Profile: "bx2-4x16", Bandwidth: 4000},
+		},
+		{
+			name:     "zone without hyphen",
+			instance: &Instance{ID: "instance-id", Zone: &Zone{Name: "zone"}},
+			expRes:   &NodeInfo{InstanceID: "instance-id", Region: "zone", Zone: "zone"},
+		},
+		{
+			name:     "empty zone",
+			instance: &Instance{ID: "instance-id", Zone: &Zone{Name: ""}},
+			expRes:   &NodeInfo{InstanceID: "instance-id"},
+		},
+		{
+			name:     "nil zone",
+			instance: &Instance{ID: "instance-id"},
+			expRes:   &NodeInfo{InstanceID: "instance-id"},
+		},
+		{
+			name:     "zone with several hyphens",
+			instance: &Instance{ID: "instance-id", Zone: &Zone{Name: "a-b-c-2"}},
+			expRes:   &NodeInfo{InstanceID: "instance-id", Region: "a-b-c", Zone: "a-b-c-2"},
+		},
 	}
 	mockupdater := initNodeLabelUpdater(t)
 	for _, tc := range testCases {
@@ -379,3 +665,1089 @@ func TestCorrectEndpointURL(t *testing.T) {
 		assert.Equal(t, tc.returnURL, url)
 	}
 }
+
+func TestGetInstanceByID(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	testCases := []struct {
+		name       string
+		instanceID string
+		expRes     *NodeInfo
+		expErr     bool
+	}{
+		{
+			name:       "existing instance",
+			instanceID: "valid-instance-id",
+			expRes:     &NodeInfo{InstanceID: "valid-instance-id", Region: "us-south", Zone: "us-south-1", Status: "running"},
+		},
+		{
+			name:       "unknown instance",
+			instanceID: "unknown-instance-id",
+			expErr:     true,
+		},
+	}
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL+"/v1/instances?generation=2&name=valid-worker")
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		nodeinfo, err := updater.GetInstanceByID(context.TODO(), tc.instanceID)
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, tc.expRes, nodeinfo)
+	}
+	// The configured list URL is left untouched.
+	assert.Equal(t, "/v1/instances", updater.StorageSecretConfig.RiaasEndpointURL.Path)
+}
+
+func TestGetWorkerDetailsWithInstanceIDHint(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1"),
+		newTestInstance("other-worker", "other-instance-id", "us-south-2", "10.0.0.2"),
+	})
+	defer riaas.Close()
+
+	// The hint is used in preference to the node name.
+	node := newTestNode("valid-worker", map[string]string{})
+	node.Annotations = map[string]string{instanceIDAnnotationKey: "other-instance-id"}
+	updater, _ := initFakeNodeLabelUpdater(t, node, riaas.URL+"/v1/instances")
+	nodeinfo, err := updater.GetWorkerDetails(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, "other-instance-id", nodeinfo.InstanceID)
+
+	// An unknown hint falls back to the lookup by name.
+	node.Annotations[instanceIDAnnotationKey] = "unknown-instance-id"
+	nodeinfo, err = updater.GetWorkerDetails(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, "valid-instance-id", nodeinfo.InstanceID)
+}
+
+func TestGetWorkerDetailsWithProviderID(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1"),
+		newTestInstance("other-worker", "other-instance-id", "us-south-2", "10.0.0.2"),
+	})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name          string
+		providerID    string
+		annotations   map[string]string
+		expInstanceID string
+	}{
+		{name: "provider ID is used in preference to the node name", providerID: "ibm://account-id///cluster-id/other-instance-id", expInstanceID: "other-instance-id"},
+		{name: "annotation is used in preference to the provider ID", providerID: "ibm://account-id///cluster-id/other-instance-id", annotations: map[string]string{instanceIDAnnotationKey: "valid-instance-id"}, expInstanceID: "valid-instance-id"},
+		{name: "absent provider ID falls back to the node name", providerID: "", expInstanceID: "valid-instance-id"},
+		{name: "unparseable provider ID falls back to the node name", providerID: "aws:///us-east-1a/other-instance-id", expInstanceID: "valid-instance-id"},
+		{name: "unknown provider ID instance falls back to the node name", providerID: "ibm://account-id///cluster-id/unknown-instance-id", expInstanceID: "valid-instance-id"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		node := newTestNode("valid-worker", map[string]string{})
+		node.Annotations = tc.annotations
+		node.Spec.ProviderID = tc.providerID
+		updater, _ := initFakeNodeLabelUpdater(t, node, riaas.URL+"/v1/instances")
+		nodeinfo, err := updater.GetWorkerDetails(context.TODO(), "valid-worker")
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expInstanceID, nodeinfo.InstanceID)
+	}
+}
+
+func TestGetWorkerDetailsMatchHostname(t *testing.T) {
+	instance := newTestInstance("vsi-0717-a1b2", "hostname-instance-id", "us-south-1", "10.0.0.1")
+	instance.Hostname = "valid-worker"
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("valid-worker", "name-instance-id", "us-south-2", "10.0.0.2"),
+		instance,
+	})
+	defer riaas.Close()
+
+	// By default an instance with the node name wins over one with the node hostname.
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL+"/v1/instances")
+	nodeinfo, err := updater.GetWorkerDetails(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, "name-instance-id", nodeinfo.InstanceID)
+
+	// MATCH_HOSTNAME matches the hostname first.
+	updater.Config = &Config{MatchHostname: true}
+	nodeinfo, err = updater.GetWorkerDetails(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.Equal(t, "hostname-instance-id", nodeinfo.InstanceID)
+
+	// It still falls back to the node name when no instance has the hostname.
+	nodeinfo, err = updater.GetWorkerDetails(context.TODO(), "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "name-instance-id", nodeinfo.InstanceID)
+
+	_, err = updater.GetWorkerDetails(context.TODO(), "unknown-worker")
+	assert.True(t, errors.Is(err, ErrInstanceNotFound))
+}
+
+func TestGetInstanceByIPSecondaryInterface(t *testing.T) {
+	multiHomed := newTestInstance("multi-homed-worker", "multi-homed-instance-id", "us-south-1", "10.0.0.1")
+	multiHomed.NetworkInterfaces = &[]NetworkInterface{
+		*multiHomed.PrimaryNetworkInterface,
+		{Name: "eth1", PrimaryIpv4Address: "10.1.0.1"},
+	}
+	// An instance without network interfaces listed must not break the scan.
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("other-worker", "other-instance-id", "us-south-1", "10.0.0.2"),
+		multiHomed,
+	})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name          string
+		ip            string
+		expInstanceID string
+	}{
+		{name: "primary interface", ip: "10.0.0.1", expInstanceID: "multi-homed-instance-id"},
+		{name: "secondary interface", ip: "10.1.0.1", expInstanceID: "multi-homed-instance-id"},
+		{name: "no interface", ip: "10.2.0.1"},
+	}
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("multi-homed-worker", map[string]string{}), riaas.URL+"/v1/instances")
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		nodeinfo, err := updater.GetInstanceByIP(context.TODO(), tc.ip, "")
+		if tc.expInstanceID == "" {
+			assert.True(t, errors.Is(err, ErrInstanceNotFound))
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expInstanceID, nodeinfo.InstanceID)
+	}
+}
+
+func TestGetWorkerDetailsHostnameFallback(t *testing.T) {
+	byHostname := newTestInstance("vsi-0717-a1b2", "hostname-instance-id", "us-south-1", "10.0.0.1")
+	byHostname.Hostname = "worker-a.internal"
+	byInterface := newTestInstance("vsi-0717-c3d4", "interface-instance-id", "us-south-1", "10.0.0.2")
+	byInterface.PrimaryNetworkInterface.Name = "worker-b"
+	riaas := newTestRIAASServer(t, []*Instance{byHostname, byInterface})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name          string
+		workerName    string
+		hostnameLabel string
+		expInstanceID string
+	}{
+		{name: "hostname label matches instance hostname", workerName: "worker-a", hostnameLabel: "worker-a.internal", expInstanceID: "hostname-instance-id"},
+		{name: "node name matches interface name", workerName: "worker-b", expInstanceID: "interface-instance-id"},
+		{name: "ip node name falls back to hostname label", workerName: "10.0.9.9", hostnameLabel: "worker-b", expInstanceID: "interface-instance-id"},
+		{name: "no match", workerName: "worker-c", hostnameLabel: "worker-c.internal"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		labels := map[string]string{}
+		if tc.hostnameLabel != "" {
+			labels[v1.LabelHostname] = tc.hostnameLabel
+		}
+		// The fallback needs no configuration.
+		updater, _ := initFakeNodeLabelUpdater(t, newTestNode(tc.workerName, labels), riaas.URL+"/v1/instances")
+		nodeinfo, err := updater.GetWorkerDetails(context.TODO(), tc.workerName)
+		if tc.expInstanceID == "" {
+			assert.True(t, errors.Is(err, ErrInstanceNotFound))
+			assert.Contains(t, err.Error(), tc.workerName+" or "+tc.hostnameLabel)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expInstanceID, nodeinfo.InstanceID)
+	}
+}
+
+func TestGetWorkerDetailsZoneHint(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{
+		newTestInstance("valid-worker", "instance-id-zone-1", "us-south-1", "10.0.0.1"),
+		newTestInstance("valid-worker", "instance-id-zone-2", "us-south-2", "10.0.0.1"),
+	})
+	defer riaas.Close()
+
+	testCases := []struct {
+		name          string
+		workerName    string
+		labels        map[string]string
+		expInstanceID string
+	}{
+		{name: "name without hint", workerName: "valid-worker", labels: map[string]string{}, expInstanceID: "instance-id-zone-1"},
+		{name: "name with topology hint", workerName: "valid-worker", labels: map[string]string{topologyZoneLabelKey: "us-south-2"}, expInstanceID: "instance-id-zone-2"},
+		{name: "name with failure domain hint", workerName: "valid-worker", labels: map[string]string{failureZoneLabelKey: "us-south-2"}, expInstanceID: "instance-id-zone-2"},
+		{name: "name with unmatched hint", workerName: "valid-worker", labels: map[string]string{topologyZoneLabelKey: "us-south-3"}, expInstanceID: "instance-id-zone-1"},
+		{name: "IP with hint", workerName: "10.0.0.1", labels: map[string]string{topologyZoneLabelKey: "us-south-2"}, expInstanceID: "instance-id-zone-2"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		updater, _ := initFakeNodeLabelUpdater(t, newTestNode(tc.workerName, tc.labels), riaas.URL+"/v1/instances")
+		nodeinfo, err := updater.GetWorkerDetails(context.TODO(), tc.workerName)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expInstanceID, nodeinfo.InstanceID)
+	}
+}
+
+func TestResolveNodeName(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "updater-pod", Namespace: "kube-system"},
+		Spec:       v1.PodSpec{NodeName: "node-from-pod"},
+	}
+	unscheduled := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pending-pod", Namespace: "kube-system"}}
+	clientset := fake.NewSimpleClientset(pod, unscheduled)
+
+	testCases := []struct {
+		name        string
+		cfg         *Config
+		expNodeName string
+		expErr      bool
+	}{
+		{
+			name:        "NODE_NAME set",
+			cfg:         &Config{NodeName: "node-from-env", PodName: "updater-pod", PodNamespace: "kube-system"},
+			expNodeName: "node-from-env",
+		},
+		{
+			name:        "resolved from pod",
+			cfg:         &Config{PodName: "updater-pod", PodNamespace: "kube-system"},
+			expNodeName: "node-from-pod",
+		},
+		{
+			name:   "pod not scheduled",
+			cfg:    &Config{PodName: "pending-pod", PodNamespace: "kube-system"},
+			expErr: true,
+		},
+		{
+			name:   "pod not found",
+			cfg:    &Config{PodName: "missing-pod", PodNamespace: "kube-system"},
+			expErr: true,
+		},
+		{
+			name:   "nothing configured",
+			cfg:    &Config{},
+			expErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		nodeName, err := ResolveNodeName(context.TODO(), clientset, tc.cfg, logger)
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, tc.expNodeName, nodeName)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	connErr := provider.Error{Fault: provider.Fault{Message: "connection failed", Wrapped: []string{"dial tcp 10.0.0.1:443: connect: connection refused"}}}
+	rateLimitErr := &ErrRIAASStatus{StatusCode: http.StatusTooManyRequests}
+	serverErr := &ErrRIAASStatus{StatusCode: http.StatusServiceUnavailable}
+	testCases := []struct {
+		name        string
+		cfg         *Config
+		err         error
+		maxAttempts int
+	}{
+		{name: "no error", cfg: &Config{}, err: nil, maxAttempts: 1},
+		{name: "other error", cfg: &Config{}, err: errors.New("unexpected"), maxAttempts: 1},
+		{name: "default connection cap", cfg: &Config{}, err: connErr, maxAttempts: maxAttempts},
+		{name: "default rate-limit cap", cfg: &Config{}, err: rateLimitErr, maxAttempts: 1},
+		{name: "default server cap", cfg: &Config{}, err: serverErr, maxAttempts: 1},
+		{name: "connection cap", cfg: &Config{RetryAttempts: map[string]int{errorClassConnection: 2}}, err: connErr, maxAttempts: 2},
+		{name: "rate-limit cap", cfg: &Config{RetryAttempts: map[string]int{errorClassRateLimit: 5, errorClassServer: 2}}, err: rateLimitErr, maxAttempts: 5},
+		{name: "server cap", cfg: &Config{RetryAttempts: map[string]int{errorClassRateLimit: 5, errorClassServer: 2}}, err: serverErr, maxAttempts: 2},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		attempts := 1
+		for tc.cfg.isRetryable(tc.err, attempts) {
+			attempts++
+		}
+		assert.Equal(t, tc.maxAttempts, attempts)
+	}
+}
+
+func TestParseRetryInterval(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       string
+		expInterval time.Duration
+		expWarning  bool
+	}{
+		{name: "valid interval", value: "3s", expInterval: 3 * time.Second},
+		{name: "invalid interval", value: "ten seconds", expInterval: defaultRetryInterval, expWarning: true},
+		{name: "zero interval", value: "0s", expInterval: defaultRetryInterval, expWarning: true},
+		{name: "negative interval", value: "-1s", expInterval: defaultRetryInterval, expWarning: true},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		logger, buf := newBufferLogger()
+		assert.Equal(t, tc.expInterval, parseRetryInterval(tc.value, logger))
+		assert.Equal(t, tc.expWarning, strings.Contains(buf.String(), "Invalid retry interval, using default"))
+	}
+}
+
+func TestConfigErrorRetry(t *testing.T) {
+	logger, _ := newBufferLogger()
+	cfg := &Config{MaxAttempts: 3, RetryInterval: "1ms"}
+
+	calls := 0
+	err := cfg.ErrorRetry(context.TODO(), logger, func() (error, bool) {
+		calls++
+		return errors.New("unexpected"), false
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = cfg.ErrorRetry(context.TODO(), logger, func() (error, bool) {
+		calls++
+		if calls < 2 {
+			return errors.New("unexpected"), false
+		}
+		return nil, false
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestErrorRetryContextCancelled(t *testing.T) {
+	logger, _ := newBufferLogger()
+	cfg := &Config{MaxAttempts: 10, RetryInterval: "1m"}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	err := cfg.ErrorRetry(ctx, logger, func() (error, bool) {
+		calls++
+		return errors.New("unexpected"), false
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// A done context stops the retries before the next attempt.
+	calls = 0
+	err = ErrorRetry(ctx, logger, func() (error, bool) {
+		calls++
+		return errors.New("unexpected"), false
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetFromVPCServerError(t *testing.T) {
+	requests := 0
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer riaas.Close()
+
+	// Server errors are not retried by default.
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL+"/v1/instances")
+	_, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	var statusErr *ErrRIAASStatus
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	assert.Equal(t, 1, requests)
+}
+
+func TestGetRiaasInstanceURL(t *testing.T) {
+	testCases := []struct {
+		name   string
+		url    string
+		expURL string
+		expErr bool
+	}{
+		{
+			name:   "empty URL",
+			url:    "",
+			expErr: true,
+		},
+		{
+			name:   "scheme-less URL",
+			url:    "us-south.iaas.cloud.ibm.com",
+			expErr: true,
+		},
+		{
+			name:   "unparseable URL",
+			url:    "https://us-south.iaas.cloud.ibm.com:port",
+			expErr: true,
+		},
+		{
+			name:   "valid URL",
+			url:    "https://us-south.iaas.cloud.ibm.com",
+			expURL: "https://us-south.iaas.cloud.ibm.com/v1/instances?generation=2&version=2020-01-01",
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		riaasInstanceURL, err := getRiaasInstanceURL(tc.url, "", 0)
+		if tc.expErr {
+			var invalidURLErr *ErrInvalidRIAASURL
+			assert.True(t, errors.As(err, &invalidURLErr))
+			assert.Equal(t, tc.url, invalidURLErr.URL)
+			assert.Contains(t, err.Error(), tc.url)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expURL, riaasInstanceURL.String())
+	}
+}
+
+func TestGetSecretProviderArgs(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	defaultArgs := map[string]string{sp.ProviderType: sp.VPC}
+	credentialKeys := []string{"account-a.toml", "account-b.toml"}
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expArgs     map[string]string
+	}{
+		{
+			name:    "no annotation",
+			expArgs: defaultArgs,
+		},
+		{
+			name:        "configured credential key",
+			annotations: map[string]string{credentialKeyAnnotationKey: "account-b.toml"},
+			expArgs:     map[string]string{sp.SecretKey: "account-b.toml"},
+		},
+		{
+			name:        "unconfigured credential key",
+			annotations: map[string]string{credentialKeyAnnotationKey: "account-c.toml"},
+			expArgs:     defaultArgs,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		node := newTestNode("valid-worker", nil)
+		node.Annotations = tc.annotations
+		assert.Equal(t, tc.expArgs, getSecretProviderArgs(node, credentialKeys, logger))
+	}
+	assert.Equal(t, defaultArgs, getSecretProviderArgs(nil, credentialKeys, logger))
+}
+
+// fakeSecretProvider is a secret provider returning fixed endpoint and token values.
+// GetIAMToken returns profileToken and records the secret it was called with. GetDefaultIAMToken
+// fails with a connection error for the first tokenConnFailures calls.
+type fakeSecretProvider struct {
+	riaasEndpoint string
+	token         string
+	tokenErr      error
+	profileToken  string
+	profileID     string
+	reasonForCall []string
+	freshToken    bool
+	// tokenConnFailures and tokenCalls count down failing and count all GetDefaultIAMToken calls.
+	tokenConnFailures int
+	tokenCalls        int
+}
+
+func (f *fakeSecretProvider) GetIAMToken(secret string, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	f.profileID = secret
+	f.reasonForCall = reasonForCall
+	f.freshToken = freshTokenRequired
+	return f.profileToken, 0, f.tokenErr
+}
+
+func (f *fakeSecretProvider) GetDefaultIAMToken(freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	f.reasonForCall = reasonForCall
+	f.freshToken = freshTokenRequired
+	f.tokenCalls++
+	if f.tokenConnFailures > 0 {
+		f.tokenConnFailures--
+		return "", 0, secretutils.Error{Description: "Error fetching iam token using api key", BackendError: "dial tcp 10.0.0.1:443: connect: connection refused"}
+	}
+	return f.token, 0, f.tokenErr
+}
+
+func (f *fakeSecretProvider) GetRIAASEndpoint(readConfig bool) (string, error) {
+	return f.riaasEndpoint, nil
+}
+
+func (f *fakeSecretProvider) GetPrivateRIAASEndpoint(readConfig bool) (string, error) {
+	return f.riaasEndpoint, nil
+}
+
+func (f *fakeSecretProvider) GetContainerAPIRoute(readConfig bool) (string, error) {
+	return "", nil
+}
+
+func (f *fakeSecretProvider) GetPrivateContainerAPIRoute(readConfig bool) (string, error) {
+	return "", nil
+}
+
+func (f *fakeSecretProvider) GetResourceGroupID() string {
+	return ""
+}
+
+// setSecretProviderFactory replaces the secret provider factory for the duration of the test.
+func setSecretProviderFactory(t *testing.T, factory secretProviderFactory) {
+	original := newSecretProvider
+	newSecretProvider = factory
+	t.Cleanup(func() { newSecretProvider = original })
+}
+
+func TestReadSecretConfigurationSecretProviderTimeout(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+	provider := &fakeSecretProvider{riaasEndpoint: "https://us-south.iaas.cloud.ibm.com", token: "valid-token"}
+
+	// Slow initialization fails with ErrSecretProviderTimeout.
+	setSecretProviderFactory(t, func(*k8s_utils.KubernetesClient, map[string]string) (secretprovider.SecretProviderInterface, error) {
+		time.Sleep(time.Second)
+		return provider, nil
+	})
+	start := time.Now()
+	_, err := ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, &Config{SecretProviderTimeout: 10 * time.Millisecond}, logger)
+	assert.True(t, errors.Is(err, ErrSecretProviderTimeout))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// A shutdown does not wait for the timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	_, err = ReadSecretConfigurationForNode(ctx, &k8sClient, nil, &Config{}, logger)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Initialization within the timeout succeeds.
+	setSecretProviderFactory(t, func(*k8s_utils.KubernetesClient, map[string]string) (secretprovider.SecretProviderInterface, error) {
+		return provider, nil
+	})
+	secretConfig, err := ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, &Config{SecretProviderTimeout: time.Second}, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, "valid-token", secretConfig.IAMAccessToken)
+	assert.Equal(t, "us-south.iaas.cloud.ibm.com", secretConfig.RiaasEndpointURL.Host)
+	assert.False(t, provider.freshToken)
+
+	// The refresh function asks the provider for a fresh token.
+	provider.token = "fresh-token"
+	token, err := secretConfig.RefreshIAMAccessToken()
+	assert.Nil(t, err)
+	assert.Equal(t, "fresh-token", token)
+	assert.True(t, provider.freshToken)
+}
+
+func TestReadSecretConfigurationIAMAuthMode(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+	fakeProvider := &fakeSecretProvider{riaasEndpoint: "https://us-south.iaas.cloud.ibm.com", token: "default-token", profileToken: "profile-token"}
+	setSecretProviderFactory(t, func(*k8s_utils.KubernetesClient, map[string]string) (secretprovider.SecretProviderInterface, error) {
+		return fakeProvider, nil
+	})
+
+	// The default mode uses the default token path.
+	secretConfig, err := ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, &Config{}, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, "default-token", secretConfig.IAMAccessToken)
+	assert.Empty(t, fakeProvider.profileID)
+	assert.Equal(t, []string{defaultIAMServiceName}, fakeProvider.reasonForCall)
+
+	// The configured service name is passed to the provider.
+	_, err = ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, &Config{IAMServiceName: "custom-service"}, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"custom-service"}, fakeProvider.reasonForCall)
+
+	// The trusted profile mode exchanges the compute resource token itself instead of asking the provider.
+	iamURL, crTokenFile := newTestIAMServer(t, "Profile-1234")
+	cfg := &Config{IAMAuthMode: IAMAuthModeTrustedProfile, TrustedProfileID: "Profile-1234",
+		TrustedProfileIAMURL: iamURL, TrustedProfileCRTokenFile: crTokenFile}
+	secretConfig, err = ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, cfg, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, "profile-token", secretConfig.IAMAccessToken)
+	assert.Empty(t, fakeProvider.profileID)
+	token, err := secretConfig.RefreshIAMAccessToken()
+	assert.Nil(t, err)
+	assert.Equal(t, "profile-token", token)
+
+	// The trusted profile mode requires a profile ID.
+	_, err = ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, &Config{IAMAuthMode: IAMAuthModeTrustedProfile}, logger)
+	assert.NotNil(t, err)
+}
+
+// newTestIAMServer starts an IAM token server which only grants "profile-token" for a compute resource token
+// exchange for profileID, and returns its URL and the file holding the compute resource token.
+func newTestIAMServer(t *testing.T, profileID string) (string, string) {
+	crTokenFile := filepath.Join(t.TempDir(), "vault-token")
+	assert.Nil(t, os.WriteFile(crTokenFile, []byte("cr-token"), 0600))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.URL.Path != "/identity/token" || r.PostForm.Get("grant_type") != "urn:ibm:params:oauth:grant-type:cr-token" ||
+			r.PostForm.Get("cr_token") != "cr-token" || r.PostForm.Get("profile_id") != profileID || r.PostForm.Has("apikey") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"profile-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, crTokenFile
+}
+
+func TestReadSecretConfigurationTrustedProfile(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	iamURL, crTokenFile := newTestIAMServer(t, "Profile-1234")
+
+	// The storage secret holds an API key, for which the provider would send the profile ID to IAM as one.
+	k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+	pwd, _ := os.Getwd()
+	assert.Nil(t, k8s_utils.FakeCreateSecret(k8sClient, "DEFAULT", filepath.Join(pwd, "..", "..", "test-fixtures", "slclient.toml")))
+
+	testCases := []struct {
+		name        string
+		crTokenFile string
+		profileID   string
+		expToken    string
+		expErr      bool
+	}{
+		{
+			name:        "profile token",
+			crTokenFile: crTokenFile,
+			profileID:   "Profile-1234",
+			expToken:    "profile-token",
+		},
+		{
+			name:        "wrong profile",
+			crTokenFile: crTokenFile,
+			profileID:   "Profile-5678",
+			expErr:      true,
+		},
+		{
+			name:        "no compute resource token",
+			crTokenFile: filepath.Join(t.TempDir(), "missing"),
+			profileID:   "Profile-1234",
+			expErr:      true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		cfg := &Config{IAMAuthMode: IAMAuthModeTrustedProfile, TrustedProfileID: tc.profileID, TrustedProfileIAMURL: iamURL,
+			TrustedProfileCRTokenFile: tc.crTokenFile, MaxAttempts: 1, RetryInterval: "1ms"}
+		secretConfig, err := ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, cfg, logger)
+		if tc.expErr {
+			assert.True(t, errors.Is(err, ErrIAMToken))
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expToken, secretConfig.IAMAccessToken)
+	}
+}
+
+func TestReadSecretConfigurationInvalidEndpoint(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+	testCases := []struct {
+		name          string
+		riaasEndpoint string
+		expErr        func(error) bool
+	}{
+		{name: "empty endpoint", riaasEndpoint: "", expErr: func(err error) bool { return errors.Is(err, ErrEmptyRIAASEndpoint) }},
+		{name: "blank endpoint", riaasEndpoint: "  ", expErr: func(err error) bool { return errors.Is(err, ErrEmptyRIAASEndpoint) }},
+		{name: "endpoint without scheme", riaasEndpoint: "us-south.iaas.cloud.ibm.com", expErr: func(err error) bool {
+			var invalidURL *ErrInvalidRIAASURL
+			return errors.As(err, &invalidURL)
+		}},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		provider := &fakeSecretProvider{riaasEndpoint: tc.riaasEndpoint, token: "valid-token"}
+		setSecretProviderFactory(t, func(*k8s_utils.KubernetesClient, map[string]string) (secretprovider.SecretProviderInterface, error) {
+			return provider, nil
+		})
+		secretConfig, err := ReadSecretConfigurationForNode(context.TODO(), &k8sClient, nil, &Config{}, logger)
+		assert.Nil(t, secretConfig)
+		assert.True(t, tc.expErr(err), "unexpected error: %v", err)
+	}
+}
+
+func TestGetRiaasInstanceURLPathPrefix(t *testing.T) {
+	riaasInstanceURL, err := getRiaasInstanceURL("https://satellite-gateway.example.com", "/riaas/us-south", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://satellite-gateway.example.com/riaas/us-south/v1/instances?generation=2&version=2020-01-01", riaasInstanceURL.String())
+
+	riaasInstanceURL, err = getRiaasInstanceURL("https://satellite-gateway.example.com", "/riaas/", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "/riaas/v1/instances", riaasInstanceURL.Path)
+
+	_, err = getRiaasInstanceURL("https://satellite-gateway.example.com", "riaas", 0)
+	var invalidURLErr *ErrInvalidRIAASURL
+	assert.True(t, errors.As(err, &invalidURLErr))
+	assert.Contains(t, err.Error(), "must start with /")
+}
+
+func TestGetRiaasInstanceURLPageLimit(t *testing.T) {
+	riaasInstanceURL, err := getRiaasInstanceURL("https://us-south.iaas.cloud.ibm.com", "", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, "100", riaasInstanceURL.Query().Get("limit"))
+	assert.Equal(t, "2", riaasInstanceURL.Query().Get("generation"))
+
+	riaasInstanceURL, err = getRiaasInstanceURL("https://us-south.iaas.cloud.ibm.com", "", 0)
+	assert.Nil(t, err)
+	assert.NotContains(t, riaasInstanceURL.Query(), "limit")
+}
+
+func TestVerifyNodeLabels(t *testing.T) {
+	labeled := newTestNode("labeled-worker", map[string]string{vpcBlockLabelKey: "true", instanceIDLabelKey: "valid-instance-id"})
+	unlabeled := newTestNode("unlabeled-worker", map[string]string{instanceIDLabelKey: "valid-instance-id"})
+	clientset := fake.NewSimpleClientset(labeled, unlabeled)
+
+	testCases := []struct {
+		name       string
+		nodeName   string
+		expPresent bool
+		expErr     bool
+	}{
+		{
+			name:       "labels present",
+			nodeName:   "labeled-worker",
+			expPresent: true,
+		},
+		{
+			name:     "labels absent",
+			nodeName: "unlabeled-worker",
+		},
+		{
+			name:     "node not found",
+			nodeName: "unknown-worker",
+			expErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		present, err := VerifyNodeLabels(context.TODO(), clientset, &Config{NodeName: tc.nodeName})
+		assert.Equal(t, tc.expErr, err != nil)
+		assert.Equal(t, tc.expPresent, present)
+	}
+	// Verification never writes to the node.
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+}
+
+func TestParseProviderID(t *testing.T) {
+	testCases := []struct {
+		name          string
+		providerID    string
+		expInstanceID string
+		expOK         bool
+	}{
+		{name: "vpc provider ID", providerID: "ibm://account-id///cluster-id/0717_a1b2c3d4-0000-1111-2222-333344445555", expInstanceID: "0717_a1b2c3d4-0000-1111-2222-333344445555", expOK: true},
+		{name: "empty provider ID", providerID: ""},
+		{name: "other provider", providerID: "aws:///us-east-1a/i-0123456789"},
+		{name: "no instance segment", providerID: "ibm://account-id///cluster-id/"},
+		{name: "no path", providerID: "ibm://account-id"},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		instanceID, ok := parseProviderID(tc.providerID)
+		assert.Equal(t, tc.expOK, ok)
+		assert.Equal(t, tc.expInstanceID, instanceID)
+	}
+}
+
+func TestGetWorkerDetailsFromInstanceListFile(t *testing.T) {
+	pwd, err := os.Getwd()
+	assert.Nil(t, err)
+	fixture := filepath.Join(pwd, "..", "..", "test-fixtures", "instance-list.json")
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	assert.Nil(t, os.WriteFile(invalid, []byte(`{"instances": {}}`), 0600))
+
+	testCases := []struct {
+		name          string
+		file          string
+		workerName    string
+		annotations   map[string]string
+		expInstanceID string
+		expZone       string
+		expErr        bool
+	}{
+		{name: "by name", file: fixture, workerName: "fixture-worker-2", expInstanceID: "0727_fixture-instance-2", expZone: "us-south-2"},
+		{name: "by IP", file: fixture, workerName: "10.240.0.4", expInstanceID: "0717_fixture-instance-1", expZone: "us-south-1"},
+		{name: "by ID hint", file: fixture, workerName: "unknown-worker", annotations: map[string]string{instanceIDAnnotationKey: "0717_fixture-instance-1"}, expInstanceID: "0717_fixture-instance-1", expZone: "us-south-1"},
+		{name: "unknown name", file: fixture, workerName: "unknown-worker", expErr: true},
+		{name: "missing file", file: filepath.Join(t.TempDir(), "missing.json"), workerName: "fixture-worker-1", expErr: true},
+		{name: "invalid file", file: invalid, workerName: "fixture-worker-1", expErr: true},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		node := newTestNode(tc.workerName, map[string]string{})
+		node.Annotations = tc.annotations
+		// The RIAAS endpoint is never called when reading from the file.
+		updater, _ := initFakeNodeLabelUpdater(t, node, "https://riaas.invalid/v1/instances")
+		updater.Config = &Config{InstanceListFile: tc.file}
+		nodeinfo, err := updater.GetWorkerDetails(context.TODO(), tc.workerName)
+		assert.Equal(t, tc.expErr, err != nil)
+		if tc.expErr {
+			continue
+		}
+		assert.Equal(t, tc.expInstanceID, nodeinfo.InstanceID)
+		assert.Equal(t, tc.expZone, nodeinfo.Zone)
+	}
+}
+
+// newPagedRIAASServer returns a server which serves the instances in pages of pageSize, linking each
+// page to the next with a start token, and records the Authorization header of every request.
+func newPagedRIAASServer(t *testing.T, instances []*Instance, pageSize int, authHeaders *[]string) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*authHeaders = append(*authHeaders, r.Header.Get("Authorization"))
+		mu.Unlock()
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		end := start + pageSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+		page := &InstanceList{Instances: instances[start:end]}
+		if end < len(instances) {
+			next := *r.URL
+			q := next.Query()
+			q.Set("start", strconv.Itoa(end))
+			next.RawQuery = q.Encode()
+			page.Next = &HReference{Href: "http://" + r.Host + next.String()}
+		}
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			t.Errorf("failed to encode instance list: %v", err)
+		}
+	}))
+}
+
+func TestGetInstancesFromVPCPagination(t *testing.T) {
+	var authHeaders []string
+	riaas := newPagedRIAASServer(t, []*Instance{
+		newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.0.0.1"),
+		newTestInstance("worker-2", "instance-id-2", "us-south-1", "10.0.0.2"),
+		newTestInstance("worker-3", "instance-id-3", "us-south-2", "10.0.0.3"),
+	}, 2, &authHeaders)
+	defer riaas.Close()
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("worker-3", map[string]string{}), riaas.URL+"/v1/instances?generation=2")
+	instances, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(instances))
+	assert.Equal(t, []string{"Bearer valid-token", "Bearer valid-token"}, authHeaders)
+
+	// An instance on the second page is found by IP.
+	nodeinfo, err := updater.GetWorkerDetails(context.TODO(), "10.0.0.3")
+	assert.Nil(t, err)
+	assert.Equal(t, "instance-id-3", nodeinfo.InstanceID)
+}
+
+func TestGetInstancesFromVPCPaginationLoop(t *testing.T) {
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := &InstanceList{
+			Instances: []*Instance{newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.0.0.1")},
+			Next:      &HReference{Href: "/v1/instances?start=same"},
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer riaas.Close()
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("worker-1", map[string]string{}), riaas.URL+"/v1/instances")
+	_, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "repeats an earlier page")
+}
+
+func TestGetInstancesFromVPCPaginationEmptyFirstPage(t *testing.T) {
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := &InstanceList{Instances: []*Instance{}}
+		switch r.URL.Query().Get("start") {
+		case "":
+			page.Next = &HReference{Href: "/v1/instances?start=1"}
+		case "1":
+			page.Next = &HReference{Href: "/v1/instances?start=2"}
+		default:
+			page.Instances = []*Instance{newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.0.0.1")}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer riaas.Close()
+
+	// Empty pages with a next link are followed rather than treated as an empty list.
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("worker-1", map[string]string{}), riaas.URL+"/v1/instances")
+	instances, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "instance-id-1", instances[0].ID)
+}
+
+func TestGetInstancesFromVPCPaginationCrossHost(t *testing.T) {
+	other := newTestRIAASServer(t, []*Instance{newTestInstance("worker-2", "instance-id-2", "us-south-1", "10.0.0.2")})
+	defer other.Close()
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := &InstanceList{
+			Instances: []*Instance{newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.0.0.1")},
+			Next:      &HReference{Href: other.URL + "/v1/instances?start=1"},
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer riaas.Close()
+
+	// The token is not sent to another host unless the redirect policy preserves it.
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("worker-1", map[string]string{}), riaas.URL+"/v1/instances")
+	_, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "to another host")
+
+	updater.Config = &Config{RedirectAuthPolicy: RedirectAuthPreserve}
+	instances, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(instances))
+}
+
+func TestGetInstancesFromVPCAuthScheme(t *testing.T) {
+	testCases := []struct {
+		name       string
+		authScheme string
+		token      string
+		expHeader  string
+	}{
+		{
+			name:      "default scheme",
+			token:     "valid-token",
+			expHeader: "Bearer valid-token",
+		},
+		{
+			name:       "lowercase scheme",
+			authScheme: "bearer",
+			token:      "valid-token",
+			expHeader:  "bearer valid-token",
+		},
+		{
+			name:       "token already carrying a scheme",
+			authScheme: "BEARER",
+			token:      "Bearer valid-token",
+			expHeader:  "BEARER valid-token",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		var authHeaders []string
+		riaas := newPagedRIAASServer(t, []*Instance{
+			newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.0.0.1"),
+		}, 1, &authHeaders)
+		updater, _ := initFakeNodeLabelUpdater(t, newTestNode("worker-1", map[string]string{}), riaas.URL+"/v1/instances")
+		updater.Config = &Config{AuthScheme: tc.authScheme}
+		updater.StorageSecretConfig.IAMAccessToken = tc.token
+		_, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+		riaas.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{tc.expHeader}, authHeaders)
+	}
+}
+
+func TestGetInstancesFromVPCRefreshToken(t *testing.T) {
+	var authHeaders []string
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer fresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&InstanceList{
+			Instances: []*Instance{newTestInstance("worker-1", "instance-id-1", "us-south-1", "10.0.0.1")},
+		})
+	}))
+	defer riaas.Close()
+
+	// A 401 refreshes the token and repeats the request once with it.
+	refreshes := 0
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("worker-1", map[string]string{}), riaas.URL+"/v1/instances")
+	updater.StorageSecretConfig.RefreshIAMAccessToken = func() (string, error) {
+		refreshes++
+		return "fresh-token", nil
+	}
+	instances, err := updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, 1, refreshes)
+	assert.Equal(t, []string{"Bearer valid-token", "Bearer fresh-token"}, authHeaders)
+	assert.Equal(t, "fresh-token", updater.StorageSecretConfig.IAMAccessToken)
+
+	// A failed refresh stops without further requests.
+	authHeaders = nil
+	updater.StorageSecretConfig.IAMAccessToken = "expired-token"
+	updater.StorageSecretConfig.RefreshIAMAccessToken = func() (string, error) {
+		return "", errors.New("iam unavailable")
+	}
+	_, err = updater.GetInstancesFromVPC(context.TODO(), updater.StorageSecretConfig.RiaasEndpointURL)
+	assert.True(t, errors.Is(err, ErrIAMToken))
+	assert.Equal(t, []string{"Bearer expired-token"}, authHeaders)
+}
+
+func TestReadSecretConfigurationRetriesTransientErrors(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+	k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+
+	testCases := []struct {
+		name         string
+		connFailures int
+		tokenErr     error
+		cancelled    bool
+		expErr       bool
+		expCalls     int
+	}{
+		{name: "transient failure succeeds on retry", connFailures: 2, expCalls: 3},
+		{name: "transient failures exhaust the attempts", connFailures: 10, expErr: true, expCalls: 3},
+		{name: "shutdown stops the retries", connFailures: 10, cancelled: true, expErr: true, expCalls: 1},
+		{name: "permanent failure fails fast", tokenErr: secretutils.Error{Description: secretutils.ErrAPIKeyNotProvided}, expErr: true, expCalls: 1},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		provider := &fakeSecretProvider{riaasEndpoint: "https://us-south.iaas.cloud.ibm.com", token: "valid-token", tokenErr: tc.tokenErr, tokenConnFailures: tc.connFailures}
+		setSecretProviderFactory(t, func(*k8s_utils.KubernetesClient, map[string]string) (secretprovider.SecretProviderInterface, error) {
+			return provider, nil
+		})
+		cfg := &Config{MaxAttempts: 3, RetryInterval: "1ms"}
+		ctx, cancel := context.WithCancel(context.Background())
+		if tc.cancelled {
+			cfg.RetryInterval = "1h"
+			time.AfterFunc(10*time.Millisecond, cancel)
+		}
+		secretConfig, err := ReadSecretConfigurationForNode(ctx, &k8sClient, nil, cfg, logger)
+		cancel()
+		assert.Equal(t, tc.expCalls, provider.tokenCalls)
+		if tc.cancelled {
+			assert.True(t, errors.Is(err, context.Canceled))
+			continue
+		}
+		if tc.expErr {
+			assert.True(t, errors.Is(err, ErrIAMToken))
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, "valid-token", secretConfig.IAMAccessToken)
+	}
+}
+
+func TestIsTransientSecretError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		expRes bool
+	}{
+		{name: "no error", err: nil, expRes: false},
+		{name: "refused dial", err: &url.Error{Op: "Post", URL: "https://iam.cloud.ibm.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, expRes: true},
+		{name: "truncated response", err: fmt.Errorf("reading token response: %w", io.ErrUnexpectedEOF), expRes: true},
+		{name: "reset connection", err: fmt.Errorf("reading token response: %w", syscall.ECONNRESET), expRes: true},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "iam.invalid", IsNotFound: true}, expRes: false},
+		{name: "DNS timeout", err: &net.DNSError{Err: "i/o timeout", Name: "iam.cloud.ibm.com", IsTimeout: true}, expRes: true},
+		{name: "flattened refused dial", err: secretutils.Error{Description: "Error fetching iam token using api key", BackendError: "dial tcp 10.0.0.1:443: connect: connection refused"}, expRes: true},
+		{name: "flattened unknown host", err: secretutils.Error{Description: "Error fetching iam token using api key", BackendError: "dial tcp: lookup iam.invalid: no such host"}, expRes: false},
+		{name: "clean EOF in a message", err: errors.New("EOF while reading the API key"), expRes: false},
+		{name: "missing API key", err: secretutils.Error{Description: secretutils.ErrAPIKeyNotProvided}, expRes: false},
+	}
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		assert.Equal(t, tc.expRes, isTransientSecretError(tc.err))
+	}
+}
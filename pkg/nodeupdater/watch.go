@@ -0,0 +1,115 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// maxRelabelBackoff bounds the backoff between relabels of a node which keep failing.
+const maxRelabelBackoff = 5 * time.Minute
+
+// WatchNodeLabels runs an informer on the worker node and relabels it whenever it is added or updated without
+// the required labels, such as after something strips them, until ctx is done. A failed relabel is retried with
+// a per-node exponential backoff starting at the retry interval, and the node's events do not retry it sooner.
+func (c *VpcNodeLabelUpdater) WatchNodeLabels(ctx context.Context, workerNodeName string) {
+	queue := workqueue.NewRateLimitingQueue(
+		workqueue.NewItemExponentialFailureRateLimiter(c.getConfig().GetRetryInterval(c.Logger), maxRelabelBackoff))
+	informer := cache.NewSharedInformer(c.nodeListWatch(ctx, workerNodeName), &v1.Node{}, 0)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { enqueueNode(queue, obj) },
+		UpdateFunc: func(_, obj interface{}) { enqueueNode(queue, obj) },
+	})
+	go informer.Run(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+
+	c.Logger.Info("Watching the node to reapply missing labels", zap.String("workerNodeName", workerNodeName))
+	if cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		for c.relabelNextNode(ctx, informer.GetStore(), queue) {
+		}
+	}
+	c.Logger.Info("Stopped watching the node", zap.String("workerNodeName", workerNodeName))
+}
+
+// nodeListWatch lists and watches only the worker node.
+func (c *VpcNodeLabelUpdater) nodeListWatch(ctx context.Context, workerNodeName string) *cache.ListWatch {
+	selector := fields.OneTermEqualSelector("metadata.name", workerNodeName).String()
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return c.K8sClient.CoreV1().Nodes().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return c.K8sClient.CoreV1().Nodes().Watch(ctx, options)
+		},
+	}
+}
+
+// enqueueNode queues the node for a relabel unless a failed relabel of it is already waiting out its backoff.
+func enqueueNode(queue workqueue.RateLimitingInterface, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil || queue.NumRequeues(key) > 0 {
+		return
+	}
+	queue.Add(key)
+}
+
+// relabelNextNode relabels the next queued node if it is missing the required labels, requeueing it with
+// backoff on failure. It returns false once the queue is shut down.
+func (c *VpcNodeLabelUpdater) relabelNextNode(ctx context.Context, store cache.Store, queue workqueue.RateLimitingInterface) bool {
+	item, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(item)
+	key := item.(string)
+	obj, exists, err := store.GetByKey(key)
+	if err != nil || !exists {
+		queue.Forget(key)
+		return true
+	}
+	node := obj.(*v1.Node)
+	if reason := c.getConfig().SkipReason(node); reason != "" {
+		RecordSkip(c.Logger, reason, "Node needs no labeling, skipping relabel", zap.String("workerNodeName", node.Name))
+		queue.Forget(key)
+		return true
+	}
+	c.Logger.Info("Node is missing required labels, relabeling", zap.String("workerNodeName", node.Name))
+	if _, err := c.UpdateNode(ctx, node.DeepCopy()); err != nil {
+		c.Logger.Error("Failed to relabel the node", zap.String("workerNodeName", node.Name),
+			zap.Int("failures", queue.NumRequeues(key)+1), zap.Error(err))
+		queue.AddRateLimited(key)
+		return true
+	}
+	queue.Forget(key)
+	return true
+}
@@ -0,0 +1,110 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWatchNodeLabels(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{RetryInterval: "10ms"}
+	skipped := SkipCounts()[SkipReasonAlreadyLabeled]
+	ctx, cancel := context.WithCancel(context.TODO())
+	stopped := make(chan struct{})
+	go func() {
+		updater.WatchNodeLabels(ctx, "valid-worker")
+		close(stopped)
+	}()
+	assert.Eventually(t, func() bool { return countActions(clientset, "watch") > 0 }, 5*time.Second, 10*time.Millisecond)
+	// The informer lists the node first, which relabels it.
+	assert.Eventually(t, func() bool {
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		return err == nil && node.Labels[instanceIDLabelKey] == "valid-instance-id"
+	}, 5*time.Second, 10*time.Millisecond)
+	// The update event for the relabeled node is counted as a skip.
+	assert.Eventually(t, func() bool { return SkipCounts()[SkipReasonAlreadyLabeled] > skipped }, 5*time.Second, 10*time.Millisecond)
+
+	// Stripping the labels triggers a relabel.
+	node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+	assert.Nil(t, err)
+	node.Labels = map[string]string{"team": "storage"}
+	_, err = clientset.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		return err == nil && node.Labels[instanceIDLabelKey] == "valid-instance-id"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Cancelling the context stops the watch.
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not stop after the context was cancelled")
+	}
+}
+
+func TestWatchNodeLabelsBacksOff(t *testing.T) {
+	riaas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer riaas.Close()
+
+	updater, clientset := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Config = &Config{RetryInterval: "100ms", MaxAttempts: 1}
+	ctx, cancel := context.WithCancel(context.TODO())
+	stopped := make(chan struct{})
+	go func() {
+		updater.WatchNodeLabels(ctx, "valid-worker")
+		close(stopped)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&updater.riaasAttempts) > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// Updates of the node while its relabel keeps failing do not retry it before its backoff, which doubles
+	// from 100ms: within 1s there are at most the attempts after 100, 300 and 700ms.
+	start := atomic.LoadInt64(&updater.riaasAttempts)
+	for i := 0; i < 50; i++ {
+		node, err := clientset.CoreV1().Nodes().Get(context.TODO(), "valid-worker", metav1.GetOptions{})
+		assert.Nil(t, err)
+		node.Annotations = map[string]string{"heartbeat": time.Now().String()}
+		_, err = clientset.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+		assert.Nil(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+	attempts := atomic.LoadInt64(&updater.riaasAttempts) - start
+	assert.True(t, attempts >= 1 && attempts <= 3, "attempts: %d", attempts)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not stop after the context was cancelled")
+	}
+}
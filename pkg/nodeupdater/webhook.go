@@ -0,0 +1,149 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	webhookDefaultTimeout       = 5 * time.Second
+	webhookDefaultMaxAttempts   = 3
+	webhookDefaultRetryInterval = time.Second
+	webhookResultSuccess        = "success"
+	webhookResultFailure        = "failure"
+	// webhookResultDryRun is the result of a successful attempt in dry-run mode, which wrote nothing.
+	webhookResultDryRun = "dry-run"
+)
+
+// LabelEvent is the payload posted to the webhook after each labeling attempt.
+type LabelEvent struct {
+	NodeName   string            `json:"node_name"`
+	InstanceID string            `json:"instance_id,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Result     string            `json:"result"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// WebhookNotifier posts node labeling events to an external webhook.
+// Delivery is best-effort and in the background: failures are logged and never returned to the caller.
+type WebhookNotifier struct {
+	URL           string
+	Timeout       time.Duration
+	MaxAttempts   int
+	RetryInterval time.Duration
+	Client        *http.Client
+	Logger        *zap.Logger
+
+	// pending tracks the deliveries in progress, see Wait.
+	pending sync.WaitGroup
+}
+
+// NewWebhookNotifier returns a notifier for webhookURL, or nil if webhookURL is empty.
+func NewWebhookNotifier(webhookURL string, timeout time.Duration, logger *zap.Logger) *WebhookNotifier {
+	if webhookURL == "" {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = webhookDefaultTimeout
+	}
+	return &WebhookNotifier{
+		URL:           webhookURL,
+		Timeout:       timeout,
+		MaxAttempts:   webhookDefaultMaxAttempts,
+		RetryInterval: webhookDefaultRetryInterval,
+		Client:        &http.Client{},
+		Logger:        logger,
+	}
+}
+
+// Notify posts the event to the webhook in the background, retrying on failure up to MaxAttempts times
+// or until ctx is done. It returns immediately, so a slow webhook never holds up labeling.
+func (w *WebhookNotifier) Notify(ctx context.Context, event *LabelEvent) {
+	if w == nil || event == nil {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		w.Logger.Warn("Failed to marshal webhook payload", zap.Error(err))
+		return
+	}
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		w.deliver(ctx, event, payload)
+	}()
+}
+
+// Wait blocks until the deliveries in progress are done, so they are not cut short by the process exiting.
+func (w *WebhookNotifier) Wait() {
+	if w == nil {
+		return
+	}
+	w.pending.Wait()
+}
+
+// deliver posts the payload of event, retrying on failure up to MaxAttempts times or until ctx is done.
+func (w *WebhookNotifier) deliver(ctx context.Context, event *LabelEvent, payload []byte) {
+	var err error
+	for attempt := 1; attempt <= w.MaxAttempts; attempt++ {
+		if err = w.post(ctx, payload); err == nil {
+			w.Logger.Info("Posted labeling event to webhook", zap.String("nodeName", event.NodeName), zap.String("result", event.Result))
+			return
+		}
+		w.Logger.Warn("Failed to post labeling event to webhook", zap.Int("attempt", attempt), zap.Error(err))
+		if attempt == w.MaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			w.Logger.Warn("Giving up posting labeling event to webhook on shutdown", zap.String("nodeName", event.NodeName), zap.Error(ctx.Err()))
+			return
+		case <-time.After(w.RetryInterval):
+		}
+	}
+	w.Logger.Error("Giving up posting labeling event to webhook", zap.String("nodeName", event.NodeName), zap.Error(err))
+}
+
+// post sends a single webhook request bounded by the notifier timeout.
+func (w *WebhookNotifier) post(ctx context.Context, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
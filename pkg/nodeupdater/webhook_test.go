@@ -0,0 +1,164 @@
+/**
+ * Copyright 2020 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+//Package nodeupdater ...
+package nodeupdater
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookReceiver records the events posted to it, failing the first failures requests.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	events   []LabelEvent
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if r.requests <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var event LabelEvent
+	if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.events = append(r.events, event)
+}
+
+func TestNewWebhookNotifier(t *testing.T) {
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+
+	assert.Nil(t, NewWebhookNotifier("", 0, logger))
+	notifier := NewWebhookNotifier("https://example.com/hook", 0, logger)
+	assert.Equal(t, webhookDefaultTimeout, notifier.Timeout)
+	assert.Equal(t, webhookDefaultMaxAttempts, notifier.MaxAttempts)
+}
+
+func TestWebhookNotify(t *testing.T) {
+	testCases := []struct {
+		name        string
+		failures    int
+		maxAttempts int
+		expRequests int
+		expEvents   int
+	}{
+		{
+			name:        "delivered on first attempt",
+			maxAttempts: 3,
+			expRequests: 1,
+			expEvents:   1,
+		},
+		{
+			name:        "delivered after retry",
+			failures:    2,
+			maxAttempts: 3,
+			expRequests: 3,
+			expEvents:   1,
+		},
+		{
+			name:        "retries exhausted",
+			failures:    5,
+			maxAttempts: 2,
+			expRequests: 2,
+			expEvents:   0,
+		},
+	}
+	logger, teardown := GetTestLogger(t)
+	defer teardown()
+
+	for _, tc := range testCases {
+		t.Logf("Test case: %s", tc.name)
+		receiver := &webhookReceiver{failures: tc.failures}
+		server := httptest.NewServer(receiver)
+		notifier := NewWebhookNotifier(server.URL, time.Second, logger)
+		notifier.MaxAttempts = tc.maxAttempts
+		notifier.RetryInterval = time.Millisecond
+		notifier.Notify(context.TODO(), &LabelEvent{NodeName: "valid-worker", InstanceID: "valid-instance-id", Result: webhookResultSuccess})
+		notifier.Wait()
+		server.Close()
+		assert.Equal(t, tc.expRequests, receiver.requests)
+		assert.Equal(t, tc.expEvents, len(receiver.events))
+	}
+}
+
+func TestUpdateNodeLabelNotifiesWebhook(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	receiver := &webhookReceiver{}
+	hook := httptest.NewServer(receiver)
+	defer hook.Close()
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Webhook = NewWebhookNotifier(hook.URL, time.Second, updater.Logger)
+	done, err := updater.UpdateNodeLabel(context.TODO(), "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	updater.Webhook.Wait()
+	assert.Equal(t, 1, len(receiver.events))
+	assert.Equal(t, webhookResultSuccess, receiver.events[0].Result)
+	assert.Equal(t, "valid-instance-id", receiver.events[0].InstanceID)
+	assert.Equal(t, "us-south", receiver.events[0].Labels[topologyRegionLabelKey])
+
+	// A failed lookup is reported but the webhook never changes the labeling result.
+	receiver.events = nil
+	updater, _ = initFakeNodeLabelUpdater(t, newTestNode("unknown-worker", map[string]string{}), riaas.URL)
+	updater.Webhook = NewWebhookNotifier(hook.URL, time.Second, updater.Logger)
+	_, err = updater.UpdateNodeLabel(context.TODO(), "unknown-worker")
+	assert.NotNil(t, err)
+	updater.Webhook.Wait()
+	assert.Equal(t, 1, len(receiver.events))
+	assert.Equal(t, webhookResultFailure, receiver.events[0].Result)
+}
+
+func TestUpdateNodeLabelDoesNotWaitForWebhook(t *testing.T) {
+	riaas := newTestRIAASServer(t, []*Instance{newTestInstance("valid-worker", "valid-instance-id", "us-south-1", "10.0.0.1")})
+	defer riaas.Close()
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer hook.Close()
+	defer close(release)
+
+	updater, _ := initFakeNodeLabelUpdater(t, newTestNode("valid-worker", map[string]string{}), riaas.URL)
+	updater.Webhook = NewWebhookNotifier(hook.URL, time.Minute, updater.Logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	done, err := updater.UpdateNodeLabel(ctx, "valid-worker")
+	assert.Nil(t, err)
+	assert.True(t, done)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// A hanging delivery still ends with its context.
+	cancel()
+	updater.Webhook.Wait()
+}